@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// A certificate manager getting certificates for hosts from Let's Encrypt
+// (or ACME_DIRECTORY_URL), keeping them in ACME_CACHE_DIR or, failing
+// that, STATE_DIR/acme
+func acmeManager(hosts string) (*autocert.Manager, error) {
+	dir := getenv("ACME_CACHE_DIR")
+	if dir == "" && getenv("STATE_DIR") != "" {
+		dir = filepath.Join(getenv("STATE_DIR"), "acme")
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("-acme-hosts needs ACME_CACHE_DIR (or STATE_DIR) to keep certificates in")
+	}
+
+	var names []string
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			names = append(names, h)
+		}
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(names...),
+		Email:      getenv("ACME_EMAIL"),
+	}
+	if u := getenv("ACME_DIRECTORY_URL"); u != "" {
+		m.Client = &acme.Client{DirectoryURL: u}
+	}
+	return m, nil
+}
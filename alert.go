@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/djl/fanatic/notify"
+	"github.com/djl/fanatic/server"
+)
+
+// Where scraping alerts go, from ALERT_WEBHOOK_URL and ALERT_COMMAND
+func alertNotifier() notify.Notifier {
+	var n notify.Multi
+	if url := getenv("ALERT_WEBHOOK_URL"); url != "" {
+		n = append(n, notify.Webhook{URL: url})
+	}
+	if cmd := getenv("ALERT_COMMAND"); cmd != "" {
+		n = append(n, notify.Command(cmd))
+	}
+	return n
+}
+
+// Alert once ALERT_THRESHOLD refreshes of the show in a row have failed,
+// and again when they start working
+func monitorFromEnv(sh show) server.Monitor {
+	n := alertNotifier()
+	return server.Monitor{
+		Threshold: envInt("ALERT_THRESHOLD", 3),
+		Alert: func(h server.Health) {
+			if err := n.Notify(healthMessage(sh, h)); err != nil {
+				log.Printf("error sending alert: %s", err)
+			}
+		},
+	}
+}
+
+// Where errors are reported besides the log, nil unless SENTRY_DSN is set
+var reporter server.ErrorReporter
+
+// The Sentry reporter configured by SENTRY_DSN, nil if it isn't set
+func reporterFromEnv() (server.ErrorReporter, error) {
+	dsn := getenv("SENTRY_DSN")
+	if dsn == "" {
+		return nil, nil
+	}
+	s, err := server.NewSentry(dsn)
+	if err != nil {
+		return nil, err
+	}
+	s.Environment = getenv("SENTRY_ENVIRONMENT")
+	s.Release = buildVersion()
+	return s, nil
+}
+
+// Report a failure to refresh the show, if errors are being reported
+func reportRefresh(ctx context.Context, sh show, err error) {
+	if sh.reporter != nil && err != nil {
+		sh.reporter.Report(ctx, err, map[string]string{"show": sh.Slug})
+	}
+}
+
+func healthMessage(sh show, h server.Health) notify.Message {
+	msg := notify.Message{URL: sh.URL, Data: h}
+	switch {
+	case h.Status == "ok":
+		msg.Title = "fanatic: scraping " + sh.Slug + " works again"
+		msg.Body = fmt.Sprintf("The feed has %d episodes.", h.Episodes)
+	case h.Empty == h.Failures:
+		msg.Title = "fanatic: no episodes found for " + sh.Slug
+		msg.Body = fmt.Sprintf("The last %d refreshes found no episodes, so KCRW's markup has probably changed. The feed was last updated %s.",
+			h.Failures, lastUpdated(h))
+	default:
+		msg.Title = "fanatic: scraping " + sh.Slug + " is failing"
+		msg.Body = fmt.Sprintf("The last %d refreshes failed: %s. The feed was last updated %s.",
+			h.Failures, h.LastError, lastUpdated(h))
+	}
+	log.Println(msg.Title)
+	return msg
+}
+
+func lastUpdated(h server.Health) string {
+	if h.LastSuccess.IsZero() {
+		return "never"
+	}
+	return h.LastSuccess.Format("2006-01-02 15:04 MST")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+// A stand-in for kcrw.com serving the fixture hub page, player JSON and
+// MP3 bytes, which also records every request it gets
+type fakeKCRW struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	hits   map[string]int
+	agents map[string]string
+}
+
+func newFakeKCRW() *fakeKCRW {
+	f := &fakeKCRW{hits: map[string]int{}, agents: map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeKCRW) showURL() string {
+	return f.URL + "/music/shows/henry-rollins"
+}
+
+// How many times a path has been requested
+func (f *fakeKCRW) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits[path]
+}
+
+// The User-Agent a path was last requested with
+func (f *fakeKCRW) agent(path string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.agents[path]
+}
+
+// Render a fixture, filling in the fake server's base URL
+func (f *fakeKCRW) fixture(name string) ([]byte, error) {
+	raw, err := testdata.ReadFile("testdata/" + name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, struct{ Base string }{f.URL})
+	return b.Bytes(), err
+}
+
+// Deterministic stand-in audio
+func fakeMP3(name string) []byte {
+	return bytes.Repeat([]byte("ID3"+name), 4096)
+}
+
+func (f *fakeKCRW) serve(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	f.hits[req.URL.Path]++
+	f.agents[req.URL.Path] = req.UserAgent()
+	f.mu.Unlock()
+
+	path := req.URL.Path
+	switch {
+	case path == "/music/shows/henry-rollins":
+		b, err := f.fixture("hub.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	case strings.HasPrefix(path, "/player/"):
+		b, err := f.fixture("player/" + strings.TrimPrefix(path, "/player/"))
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case strings.HasPrefix(path, "/audio/"):
+		w.Header().Set("Content-Type", "audio/mpeg")
+		http.ServeContent(w, req, path, startTime, bytes.NewReader(fakeMP3(path)))
+	default:
+		http.NotFound(w, req)
+	}
+}
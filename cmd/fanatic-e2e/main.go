@@ -0,0 +1,741 @@
+// Command fanatic-e2e runs the real fanatic binary against a fake KCRW and
+// checks what it serves end to end.
+//
+// Run it from the repository root with
+//
+//	go run ./cmd/fanatic-e2e
+//
+// which builds fanatic first, or point it at an existing binary with -bin.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var startTime = time.Now()
+
+// A running fanatic process
+type instance struct {
+	cmd  *exec.Cmd
+	base string
+	kcrw *fakeKCRW
+}
+
+// A single end-to-end assertion
+type check struct {
+	name string
+	run  func(*instance) error
+}
+
+var checks = []check{
+	{"landing page", checkLanding},
+	{"static assets", checkStatic},
+	{"feed", checkFeed},
+	{"show feed", checkShowFeed},
+	{"feed head", checkFeedHead},
+	{"user agent", checkUserAgent},
+	{"unknown path", checkNotFound},
+	{"request id", checkRequestID},
+	{"debug vars", checkDebugVars},
+	{"health", checkHealth},
+	{"status", checkStatus},
+	{"opml", checkOPML},
+	{"episodes api", checkEpisodesAPI},
+	{"episode detail api", checkEpisodeAPI},
+	{"media proxy", checkMediaProxy},
+	{"generate", checkGenerate},
+	{"list", checkList},
+	{"validate", checkValidate},
+	{"publish to directory", checkPublishDir},
+	{"record", checkRecord},
+}
+
+func main() {
+	bin := flag.String("bin", "", "fanatic binary to test (built from . if empty)")
+	verbose := flag.Bool("v", false, "show fanatic's log output")
+	flag.Parse()
+
+	if *bin == "" {
+		dir, err := ioutil.TempDir("", "fanatic-e2e")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		*bin = filepath.Join(dir, "fanatic")
+		build := exec.Command("go", "build", "-o", *bin, ".")
+		build.Stdout, build.Stderr = os.Stdout, os.Stderr
+		if err := build.Run(); err != nil {
+			log.Fatalf("building fanatic: %s", err)
+		}
+	}
+
+	kcrw := newFakeKCRW()
+	defer kcrw.Close()
+
+	inst, err := start(*bin, kcrw, *verbose)
+	if err != nil {
+		log.Fatalf("starting fanatic: %s", err)
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(inst); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	if err := inst.stop(); err != nil {
+		failed++
+		fmt.Printf("FAIL shutdown: %s\n", err)
+	} else {
+		fmt.Println("ok   shutdown")
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d check(s) failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+// Pick a port nothing is listening on
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	return port, err
+}
+
+// Start fanatic pointed at the fake KCRW and wait until it serves requests
+func start(bin string, kcrw *fakeKCRW, verbose bool) (*instance, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(bin, "serve")
+	cmd.Env = append(os.Environ(),
+		"PORT="+port,
+		"KCRW_URL="+kcrw.showURL(),
+		"USER_AGENT="+userAgent,
+		"ADMIN_TOKEN="+adminToken,
+	)
+	if verbose {
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	inst := &instance{cmd: cmd, base: "http://127.0.0.1:" + port, kcrw: kcrw}
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		res, err := http.Get(inst.base + "/")
+		if err == nil {
+			res.Body.Close()
+			return inst, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cmd.Process.Kill()
+	return nil, errors.New("timed out waiting for fanatic to listen")
+}
+
+// Ask fanatic to shut down and make sure it exits cleanly
+func (inst *instance) stop() error {
+	if err := inst.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- inst.cmd.Wait() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		inst.cmd.Process.Kill()
+		return errors.New("timed out waiting for fanatic to exit")
+	}
+}
+
+// Fetch a path from fanatic, returning the response and its body
+func (inst *instance) get(path string) (*http.Response, string, error) {
+	req, err := http.NewRequest("GET", inst.base+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return fetch(req)
+}
+
+// Fetch a path only served with ADMIN_TOKEN
+func (inst *instance) getAsAdmin(path string) (*http.Response, string, error) {
+	req, err := http.NewRequest("GET", inst.base+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	return fetch(req)
+}
+
+func fetch(req *http.Request) (*http.Response, string, error) {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	return res, string(body), err
+}
+
+func expectStatus(res *http.Response, code int) error {
+	if res.StatusCode != code {
+		return fmt.Errorf("got status %d, want %d", res.StatusCode, code)
+	}
+	return nil
+}
+
+func checkLanding(inst *instance) error {
+	res, body, err := inst.get("/")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	if !strings.Contains(body, `href="/rss.xml"`) {
+		return errors.New("landing page doesn't link to the feed")
+	}
+	if !strings.Contains(body, "KCRW Broadcast 763") {
+		return errors.New("landing page doesn't list the episodes")
+	}
+	return nil
+}
+
+func checkStatic(inst *instance) error {
+	res, body, err := inst.get("/static/style.css")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/css") || body == "" {
+		return fmt.Errorf("stylesheet has type %q and %d bytes", ct, len(body))
+	}
+
+	res, _, err = inst.get("/static/")
+	if err != nil {
+		return err
+	}
+	return expectStatus(res, http.StatusNotFound)
+}
+
+// Just enough of RSS to check the feed's items
+type rss struct {
+	Items []struct {
+		Title     string `xml:"title"`
+		GUID      string `xml:"guid"`
+		Enclosure struct {
+			URL    string `xml:"url,attr"`
+			Length string `xml:"length,attr"`
+			Type   string `xml:"type,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+}
+
+func checkFeed(inst *instance) error {
+	res, body, err := inst.get("/rss.xml")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.Contains(ct, "xml") {
+		return fmt.Errorf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(res.Header.Get("Surrogate-Key"), "feed") {
+		return errors.New("feed isn't tagged with the feed surrogate key")
+	}
+	return expectFeed(inst, body)
+}
+
+func checkFeedHead(inst *instance) error {
+	_, body, err := inst.get("/rss.xml")
+	if err != nil {
+		return err
+	}
+
+	res, err := http.Head(inst.base + "/rss.xml")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	if res.ContentLength != int64(len(body)) {
+		return fmt.Errorf("HEAD gave Content-Length %d, the feed is %d bytes", res.ContentLength, len(body))
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" || res.Header.Get("Last-Modified") == "" {
+		return errors.New("HEAD gave no ETag or Last-Modified")
+	}
+
+	req, err := http.NewRequest("GET", inst.base+"/rss.xml", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("If-None-Match", etag)
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		return err
+	}
+	res.Body.Close()
+	return expectStatus(res, http.StatusNotModified)
+}
+
+func checkShowFeed(inst *instance) error {
+	res, body, err := inst.get("/shows/henry-rollins/rss.xml")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	return expectFeed(inst, body)
+}
+
+// Parse a feed and check it has the fixture episodes
+func expectFeed(inst *instance, body string) error {
+	var feed rss
+	if err := xml.Unmarshal([]byte(body), &feed); err != nil {
+		return fmt.Errorf("parsing feed: %s", err)
+	}
+
+	// the hub page lists a broken episode and one without player JSON,
+	// both of which should be skipped
+	if len(feed.Items) != 3 {
+		return fmt.Errorf("got %d items, want 3", len(feed.Items))
+	}
+	for _, item := range feed.Items {
+		if item.GUID == "" {
+			return fmt.Errorf("item %q has no guid", item.Title)
+		}
+		if !strings.HasPrefix(item.Enclosure.URL, inst.kcrw.URL+"/audio/") {
+			return fmt.Errorf("item %q has enclosure %q", item.Title, item.Enclosure.URL)
+		}
+		path := strings.TrimPrefix(item.Enclosure.URL, inst.kcrw.URL)
+		if want := strconv.Itoa(len(fakeMP3(path))); item.Enclosure.Length != want {
+			return fmt.Errorf("item %q has enclosure length %q, want %s", item.Title, item.Enclosure.Length, want)
+		}
+		if item.Enclosure.Type != "audio/mpeg" {
+			return fmt.Errorf("item %q has enclosure type %q", item.Title, item.Enclosure.Type)
+		}
+	}
+	return nil
+}
+
+// Run a one-off fanatic command against the fake KCRW, returning its stdout
+func (inst *instance) run(args ...string) (string, error) {
+	cmd := exec.Command(inst.cmd.Path, args...)
+	cmd.Env = append(os.Environ(), "KCRW_URL="+inst.kcrw.showURL())
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return string(out), fmt.Errorf("%s\n%s", err, stderr.String())
+	}
+	return string(out), nil
+}
+
+func checkGenerate(inst *instance) error {
+	dir, err := ioutil.TempDir("", "fanatic-e2e")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "rss.xml")
+	if _, err := inst.run("generate", "-o", out); err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadFile(out)
+	if err != nil {
+		return err
+	}
+	return expectFeed(inst, string(body))
+}
+
+func checkList(inst *instance) error {
+	out, err := inst.run("list", "-json")
+	if err != nil {
+		return err
+	}
+
+	var episodes []struct {
+		Title    string `json:"title"`
+		Duration int    `json:"duration"`
+	}
+	if err := json.Unmarshal([]byte(out), &episodes); err != nil {
+		return err
+	}
+	if len(episodes) != 3 {
+		return fmt.Errorf("got %d episodes, want 3", len(episodes))
+	}
+	if episodes[0].Duration != 7200 {
+		return fmt.Errorf("got duration %d, want 7200", episodes[0].Duration)
+	}
+	return nil
+}
+
+func checkValidate(inst *instance) error {
+	out, err := inst.run("validate")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "feed ok") {
+		return fmt.Errorf("unexpected output %q", out)
+	}
+	return nil
+}
+
+// What fanatic is told to identify itself to KCRW as
+const userAgent = "fanatic-e2e (+https://example.com)"
+
+// The token the admin routes, /status and /debug/vars need
+const adminToken = "e2e-admin-token"
+
+func checkUserAgent(inst *instance) error {
+	path := strings.TrimPrefix(inst.kcrw.showURL(), inst.kcrw.URL)
+	if got := inst.kcrw.agent(path); got != userAgent {
+		return fmt.Errorf("show page fetched as %q, want %q", got, userAgent)
+	}
+	return nil
+}
+
+func checkNotFound(inst *instance) error {
+	res, _, err := inst.get("/no-such-page")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusNotFound); err != nil {
+		return err
+	}
+
+	// Browsers get a page styled like the rest of the site
+	req, err := http.NewRequest("GET", inst.base+"/no-such-page", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/html")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusNotFound); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(body), "/static/style.css") {
+		return fmt.Errorf("not an HTML error page: %s", res.Header.Get("Content-Type"))
+	}
+	return nil
+}
+
+func checkRequestID(inst *instance) error {
+	req, err := http.NewRequest("GET", inst.base+"/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Request-Id", "e2e-check")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if id := res.Header.Get("X-Request-Id"); id != "e2e-check" {
+		return fmt.Errorf("got request id %q, want e2e-check", id)
+	}
+	return nil
+}
+
+func checkDebugVars(inst *instance) error {
+	res, _, err := inst.get("/debug/vars")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusUnauthorized); err != nil {
+		return fmt.Errorf("without the token: %s", err)
+	}
+
+	res, body, err := inst.getAsAdmin("/debug/vars")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	if !strings.Contains(body, `"media_transfers_started"`) {
+		return errors.New("transfer counters missing")
+	}
+	for _, name := range []string{"refreshes", "refresh_failures", "feeds_served", "episodes_served", "goroutines"} {
+		if !strings.Contains(body, `"`+name+`"`) {
+			return fmt.Errorf("%s missing", name)
+		}
+	}
+	return nil
+}
+
+func checkHealth(inst *instance) error {
+	res, body, err := inst.get("/healthz")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	var h struct {
+		Status   string `json:"status"`
+		Episodes int    `json:"episodes"`
+	}
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
+		return err
+	}
+	if h.Status != "ok" || h.Episodes != 3 {
+		return fmt.Errorf("unexpected health %+v", h)
+	}
+	return nil
+}
+
+func checkStatus(inst *instance) error {
+	res, _, err := inst.get("/status")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusUnauthorized); err != nil {
+		return fmt.Errorf("without the token: %s", err)
+	}
+
+	res, body, err := inst.getAsAdmin("/status")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	var s struct {
+		Version string `json:"version"`
+		Shows   []struct {
+			Slug        string     `json:"slug"`
+			Episodes    int        `json:"episodes"`
+			NextRefresh *time.Time `json:"next_refresh"`
+		} `json:"shows"`
+	}
+	if err := json.Unmarshal([]byte(body), &s); err != nil {
+		return err
+	}
+	if s.Version == "" || len(s.Shows) != 1 || s.Shows[0].Episodes != 3 || s.Shows[0].NextRefresh == nil {
+		return fmt.Errorf("unexpected status %s", body)
+	}
+	return nil
+}
+
+func checkOPML(inst *instance) error {
+	res, body, err := inst.get("/opml.xml")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	var doc struct {
+		Outlines []struct {
+			XMLURL string `xml:"xmlUrl,attr"`
+		} `xml:"body>outline"`
+	}
+	if err := xml.Unmarshal([]byte(body), &doc); err != nil {
+		return err
+	}
+	if len(doc.Outlines) != 1 || doc.Outlines[0].XMLURL != inst.base+"/shows/henry-rollins/rss.xml" {
+		return fmt.Errorf("unexpected outlines %+v", doc.Outlines)
+	}
+	return nil
+}
+
+func checkEpisodesAPI(inst *instance) error {
+	res, body, err := inst.get("/api/episodes")
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	var episodes []struct {
+		UUID string `json:"uuid"`
+		MP3  string `json:"mp3"`
+	}
+	if err := json.Unmarshal([]byte(body), &episodes); err != nil {
+		return err
+	}
+	if len(episodes) != 3 {
+		return fmt.Errorf("got %d episodes, want 3", len(episodes))
+	}
+	for _, e := range episodes {
+		if e.UUID == "" || !strings.HasPrefix(e.MP3, inst.kcrw.URL+"/audio/") {
+			return fmt.Errorf("unexpected episode %+v", e)
+		}
+	}
+
+	res, _, err = inst.get("/api/episodes?show=nope")
+	if err != nil {
+		return err
+	}
+	return expectStatus(res, http.StatusNotFound)
+}
+
+func checkEpisodeAPI(inst *instance) error {
+	const id = "e2e00000-0000-4000-8000-000000000763"
+	res, body, err := inst.get("/api/episodes/" + id)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(res, http.StatusOK); err != nil {
+		return err
+	}
+	var episode struct {
+		UUID   string `json:"uuid"`
+		Show   string `json:"show"`
+		Source struct {
+			UUID string `json:"uuid"`
+		} `json:"source"`
+	}
+	if err := json.Unmarshal([]byte(body), &episode); err != nil {
+		return err
+	}
+	if episode.UUID != id || episode.Show != "henry-rollins" || episode.Source.UUID != id {
+		return fmt.Errorf("unexpected episode %+v", episode)
+	}
+
+	res, _, err = inst.get("/api/episodes/nope")
+	if err != nil {
+		return err
+	}
+	return expectStatus(res, http.StatusNotFound)
+}
+
+func checkMediaProxy(inst *instance) error {
+	req, err := http.NewRequest("GET", inst.base+"/media/e2e00000-0000-4000-8000-000000000763.mp3", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=10-19")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := expectStatus(res, http.StatusPartialContent); err != nil {
+		return err
+	}
+	mp3 := fakeMP3("/audio/763.mp3")
+	if want := fmt.Sprintf("bytes 10-19/%d", len(mp3)); res.Header.Get("Content-Range") != want {
+		return fmt.Errorf("got Content-Range %q, want %q", res.Header.Get("Content-Range"), want)
+	}
+	if !bytes.Equal(body, mp3[10:20]) {
+		return fmt.Errorf("got bytes %q, want %q", body, mp3[10:20])
+	}
+
+	res, _, err = inst.get("/media/nope.mp3")
+	if err != nil {
+		return err
+	}
+	return expectStatus(res, http.StatusNotFound)
+}
+
+func checkPublishDir(inst *instance) error {
+	dir, err := ioutil.TempDir("", "fanatic-e2e")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := inst.run("publish", "-dir", dir); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"index.html", "rss.xml", "shows/henry-rollins/rss.xml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	body, err := ioutil.ReadFile(filepath.Join(dir, "rss.xml"))
+	if err != nil {
+		return err
+	}
+	return expectFeed(inst, string(body))
+}
+
+func checkRecord(inst *instance) error {
+	dir, err := ioutil.TempDir("", "fanatic-e2e")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := inst.run("record", "-dir", dir); err != nil {
+		return err
+	}
+
+	// the hub page, the player JSON for every episode (including the
+	// missing one) and a HEAD of each episode's MP3
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) != 8 {
+		return fmt.Errorf("recorded %d responses, want 8", len(files))
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/djl/fanatic/fixture"
+	"github.com/djl/fanatic/scraper"
+	"github.com/djl/fanatic/server"
+)
+
+// A file making up the static version of the site
+type siteFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+const rssType = "application/rss+xml; charset=utf-8"
+
+// Everything needed to host the site without fanatic running, given each
+// show's feed and episodes with the default show's first
+func siteFiles(shows []show, feeds []string, episodes [][]scraper.Episode) ([]siteFile, error) {
+	t, err := landingTemplate()
+	if err != nil {
+		return nil, err
+	}
+	// The default show's feed is at FEED_PATH, as the feed's own links say
+	feedPath, err := parseFeedPath(getenv("FEED_PATH"))
+	if err != nil {
+		return nil, err
+	}
+	base := baseURL()
+	data := server.Page{Episodes: episodes[0], Updated: time.Now(), Base: base}
+	for i, sh := range shows {
+		url := base + "/shows/" + sh.Slug + "/rss.xml"
+		if i == 0 {
+			url = base + feedPath
+		}
+		data.Feeds = append(data.Feeds, server.PageFeed{Slug: sh.Slug, Title: sh.builder().Title, URL: url})
+	}
+	page, err := server.LandingPage(t, data)
+	if err != nil {
+		return nil, err
+	}
+	files := []siteFile{
+		{"index.html", "text/html; charset=utf-8", page},
+		{strings.TrimPrefix(feedPath, "/"), rssType, []byte(feeds[0])},
+	}
+
+	assets := server.Assets(getenv("STATIC_DIR"))
+	err = fs.WalkDir(assets, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, siteFile{"static/" + name, mime.TypeByExtension(path.Ext(name)), data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, sh := range shows {
+		files = append(files, siteFile{"shows/" + sh.Slug + "/rss.xml", rssType, []byte(feeds[i])})
+		for n := 1; ; n++ {
+			xml, ok := sh.archive(episodes[i], n)
+			if !ok {
+				break
+			}
+			name := fmt.Sprintf("shows/%s/archive/%d.xml", sh.Slug, n)
+			files = append(files, siteFile{name, rssType, []byte(xml)})
+		}
+	}
+	return files, nil
+}
+
+// Write data to a temporary file next to path, ready to be renamed into
+// place so a web server never sees a partially written file
+func writeTemp(path string, data []byte) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Write the feed to path ("-" for stdout)
+func writeFeed(xml string, path string) error {
+	if path == "-" {
+		_, err := os.Stdout.WriteString(xml)
+		return err
+	}
+
+	tmp, err := writeTemp(path, []byte(xml))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Write the site's files into dir. Every file is written out before any is
+// renamed into place, so a failure part way leaves the previous version
+// intact rather than a mix of old and new files
+func writeSite(dir string, files []siteFile) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var tmps []string
+	defer func() {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}()
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		tmp, err := writeTemp(path, f.data)
+		if err != nil {
+			return err
+		}
+		tmps = append(tmps, tmp)
+	}
+
+	for i, f := range files {
+		if err := os.Rename(tmps[i], filepath.Join(dir, f.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate the feed once and write it out, e.g. from cron
+func cmdGenerate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	out := flags.String("o", "-", "file to write the feed to (- for stdout)")
+	format := flags.String("xml", getenv("FEED_XML"), "write feeds indented (pretty) or without indentation (compact)")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	if _, err := parseXMLFormat(*format); err != nil {
+		return err
+	}
+	xmlFormat = *format
+
+	sh, err := selectedShow()
+	if err != nil {
+		return err
+	}
+	xml, _, err := sh.generate()
+	if err != nil {
+		return fmt.Errorf("error generating XML: %s", err)
+	}
+	return writeFeed(xml, *out)
+}
+
+// Print the scraped episodes as a table or JSON
+func cmdList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	sh, err := selectedShow()
+	if err != nil {
+		return err
+	}
+	_, episodes, err := sh.generate()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(episodes)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tDURATION\tTITLE\tMP3")
+	for _, e := range episodes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.PubDate.Format("2006-01-02"), e.Duration, e.Title, e.MP3)
+	}
+	return tw.Flush()
+}
+
+// An episode as written by fanatic export
+type exportedEpisode struct {
+	Title    string    `json:"title"`
+	PubDate  time.Time `json:"pubdate"`
+	Duration int64     `json:"duration"`
+	MP3      string    `json:"mp3"`
+	UUID     string    `json:"uuid"`
+}
+
+// Write every episode of the show fanatic knows about, those saved in
+// STATE_DIR along with a fresh scrape, as CSV or JSON for spreadsheets and
+// archiving
+func cmdExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "csv", "csv or json")
+	out := flags.String("o", "-", "file to write to (- for stdout)")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q, want csv or json", *format)
+	}
+	sh, err := selectedShow()
+	if err != nil {
+		return err
+	}
+
+	var saved []scraper.Episode
+	if dir := getenv("STATE_DIR"); dir != "" {
+		f, err := readSavedFeed(dir, sh.Slug)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading saved feed: %s", err)
+		}
+		saved = f.Episodes
+	}
+
+	// A failed scrape still leaves the saved episodes to export
+	_, episodes, err := sh.generate()
+	if err != nil {
+		if len(saved) == 0 {
+			return err
+		}
+		log.Printf("error scraping, exporting saved episodes only: %s", err)
+	}
+	episodes = mergeEpisodes(episodes, saved)
+
+	var rows []exportedEpisode
+	for _, e := range episodes {
+		rows = append(rows, exportedEpisode{e.Title, e.PubDate, int64(e.Duration.Seconds()), e.MP3, e.UUID})
+	}
+
+	var buf bytes.Buffer
+	if *format == "json" {
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			return err
+		}
+	} else {
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"title", "pubdate", "duration", "mp3", "uuid"})
+		for _, r := range rows {
+			w.Write([]string{r.Title, r.PubDate.Format(time.RFC3339), strconv.FormatInt(r.Duration, 10), r.MP3, r.UUID})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	return writeFeed(buf.String(), *out)
+}
+
+// Check a feed (freshly generated, or read from a file) for problems,
+// failing if there are any
+func cmdValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := flags.String("f", "", "validate this feed file instead of generating one")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	var xml string
+	if *file != "" {
+		b, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		xml = string(b)
+	} else {
+		sh, err := selectedShow()
+		if err != nil {
+			return err
+		}
+		xml, _, err = sh.generate()
+		if err != nil {
+			return fmt.Errorf("error generating XML: %s", err)
+		}
+	}
+
+	problems := validateFeed([]byte(xml))
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	fmt.Println("feed ok")
+	return nil
+}
+
+// Generate the site and write it to a directory (for GitHub Pages, Netlify
+// and the like) or upload it to an S3-compatible bucket, so it can be
+// hosted without fanatic running as a server
+func cmdPublish(args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	dir := flags.String("dir", "", "write the site to this directory instead of a bucket")
+	format := flags.String("xml", getenv("FEED_XML"), "write feeds indented (pretty) or without indentation (compact)")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	if _, err := parseXMLFormat(*format); err != nil {
+		return err
+	}
+	xmlFormat = *format
+
+	shows, err := configuredShows()
+	if err != nil {
+		return err
+	}
+
+	var b *bucket
+	if *dir == "" {
+		if b, err = bucketFromEnv(); err != nil {
+			return err
+		}
+	}
+
+	// Scrape every show once, building combined shows from the episodes
+	// of the others
+	feeds := make([]string, len(shows))
+	scraped := map[string][]scraper.Episode{}
+	for _, combined := range []bool{false, true} {
+		for i, sh := range shows {
+			if (len(sh.sources) > 0) != combined {
+				continue
+			}
+
+			var xml string
+			var episodes []scraper.Episode
+			if combined {
+				var lists [][]scraper.Episode
+				for _, src := range sh.sources {
+					lists = append(lists, scraped[src.Slug])
+				}
+				xml, episodes, err = sh.build(sh.combine(lists))
+			} else {
+				xml, episodes, err = sh.generate()
+			}
+			if err != nil {
+				return fmt.Errorf("error generating XML for %s: %s", sh.Slug, err)
+			}
+			feeds[i], scraped[sh.Slug] = xml, episodes
+		}
+	}
+	var episodes [][]scraper.Episode
+	for _, sh := range shows {
+		episodes = append(episodes, scraped[sh.Slug])
+	}
+	files, err := siteFiles(shows, feeds, episodes)
+	if err != nil {
+		return err
+	}
+
+	if *dir != "" {
+		if err := writeSite(*dir, files); err != nil {
+			return err
+		}
+		log.Printf("wrote site to %s", *dir)
+		return nil
+	}
+
+	// Only bother WebSub and Podping about feeds that really changed
+	var changed []show
+	for i, sh := range shows {
+		old, err := b.get("shows/" + sh.Slug + "/rss.xml")
+		if err != nil && err != errNoSuchKey {
+			log.Printf("error reading published feed: %s", err)
+		}
+		if string(old) != feeds[i] {
+			changed = append(changed, sh)
+		}
+	}
+
+	cc := fmt.Sprintf("public, max-age=%d", int(cachePolicyFromEnv().MaxAge.Seconds()))
+	for _, f := range files {
+		if err := b.put(f.name, f.data, f.contentType, cc); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("published site to %s", b.objectURL(""))
+	for _, sh := range changed {
+		sh.announce()
+	}
+	return nil
+}
+
+// Scrape KCRW, saving every response under a directory so it can be
+// replayed later without the network
+func cmdRecord(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	dir := flags.String("dir", "testdata/kcrw", "directory to save responses to")
+	flags.StringVar(&showSlug, "show", "", "slug of the show to record (default: the default show)")
+	flags.Parse(args)
+
+	sh, err := selectedShow()
+	if err != nil {
+		return err
+	}
+	s := sh.scraper()
+	s.Transport = &fixture.Recorder{Dir: *dir}
+	episodes, err := s.Episodes()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("recorded %d episodes to %s", len(episodes), *dir)
+	return nil
+}
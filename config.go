@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+
+	"github.com/djl/fanatic/scraper"
+	"github.com/djl/fanatic/server"
+)
+
+// Settings read from the JSON file named by FANATIC_CONFIG, for things
+// too unwieldy for environment variables
+type config struct {
+	// Where episodes are found in KCRW's markup, so a redesign can be
+	// followed without a new build
+	Selectors scraper.Selectors `json:"selectors"`
+
+	// Tried in order when selectors find nothing, with missing fields
+	// taken from selectors. Replaces scraper.DefaultFallbacks
+	Fallbacks []scraper.Selectors `json:"fallbacks"`
+
+	// Which of an episode's media to use for its enclosure
+	Media scraper.MediaPreference `json:"media"`
+
+	// Credentials for the admin and debugging routes. ADMIN_TOKEN sets the
+	// token too
+	Auth server.Auth `json:"auth"`
+
+	// Other sites allowed to read the JSON API from the browser.
+	// CORS_ORIGINS sets the origins too
+	CORS server.CORS `json:"cors"`
+
+	// Serve profiles at /debug/pprof/, which needs auth set
+	Pprof bool `json:"pprof"`
+
+	// An html/template file rendering the landing page in place of the
+	// built in one, executed with a server.Page
+	LandingTemplate string `json:"landing_template"`
+
+	// The URL fanatic is publicly reached at, e.g.
+	// https://fanatic.example.com when it's behind a reverse proxy, for
+	// absolute links to it. BASE_URL sets it too
+	BaseURL string `json:"base_url"`
+
+	// Proxy every outbound request goes through, e.g.
+	// http://proxy.example.com:3128, in place of HTTP_PROXY and
+	// HTTPS_PROXY. NO_PROXY still applies
+	Proxy string `json:"proxy"`
+
+	// Values for settings otherwise taken from the environment, which
+	// takes precedence
+	Settings settings `json:"settings"`
+
+	// Shows to serve, and the slug of the one also served at /rss.xml
+	// (the first if empty)
+	Shows       []show `json:"shows"`
+	DefaultShow string `json:"default_show"`
+}
+
+var conf config
+
+// Load the config file, if there is one. conf is left alone if it can't
+// be read
+func loadConfig() error {
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	setConf(c)
+	return nil
+}
+
+// Read the config file, or return the config as it is if there's none
+func readConfig() (config, error) {
+	path := os.Getenv("FANATIC_CONFIG")
+	if path == "" {
+		return currentConf(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return config{}, err
+	}
+	defer f.Close()
+
+	var c config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return config{}, fmt.Errorf("reading %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// The config as it is now, to be read rather than conf itself by anything
+// that may run while it's reloaded
+func currentConf() config {
+	confMu.RLock()
+	defer confMu.RUnlock()
+	return conf
+}
+
+// Replace the config, e.g. when reloading
+func setConf(c config) {
+	confMu.Lock()
+	conf = c
+	confMu.Unlock()
+}
+
+// The landing page template set by the config file, nil for the built in
+// one
+func landingTemplate() (*template.Template, error) {
+	c := currentConf()
+	if c.LandingTemplate == "" {
+		return nil, nil
+	}
+	t, err := server.ParseLandingTemplate(c.LandingTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("reading landing_template: %s", err)
+	}
+	return t, nil
+}
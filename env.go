@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+	"github.com/djl/fanatic/server"
+)
+
+// Read an integer setting
+func envInt(name string, def int) int {
+	v := getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %s", name, err)
+	}
+	return n
+}
+
+// Read a number setting such as "2.5"
+func envFloat(name string, def float64) float64 {
+	v := getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("invalid %s: %s", name, err)
+	}
+	return f
+}
+
+// Read a duration setting such as "90s" or "1h"
+func envDuration(name string, def time.Duration) time.Duration {
+	v := getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %s", name, err)
+	}
+	return d
+}
+
+// The show page to scrape
+func kcrwURL() string {
+	if u := getenv("KCRW_URL"); u != "" {
+		return u
+	}
+	return scraper.DefaultURL
+}
+
+// The time zone KCRW's dates are read in when they don't give one, from
+// KCRW_TZ
+func kcrwLocation() (*time.Location, error) {
+	tz := getenv("KCRW_TZ")
+	if tz == "" {
+		tz = "America/Los_Angeles"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KCRW_TZ: %s", err)
+	}
+	return loc, nil
+}
+
+func cachePolicyFromEnv() server.CachePolicy {
+	return server.CachePolicy{
+		MaxAge:     envDuration("CACHE_MAX_AGE", 5*time.Minute),
+		CDNMaxAge:  envDuration("CDN_MAX_AGE", time.Hour),
+		PurgeURL:   getenv("CDN_PURGE_URL"),
+		PurgeToken: getenv("CDN_PURGE_TOKEN"),
+	}
+}
+
+func optionsFromEnv() server.Options {
+	commit, built := buildCommit()
+	return server.Options{
+		Cache:            cachePolicyFromEnv(),
+		MaxRequests:      envInt("MAX_REQUESTS", 256),
+		MaxStreams:       envInt("MAX_STREAMS", 64),
+		RetryAfter:       envDuration("RETRY_AFTER", 30*time.Second),
+		RateLimit:        envFloat("RATE_LIMIT", 0),
+		RateBurst:        envInt("RATE_BURST", 10),
+		MediaIdleTimeout: envDuration("MEDIA_IDLE_TIMEOUT", 30*time.Second),
+		MediaDeadline:    envDuration("MEDIA_DEADLINE", time.Hour),
+		StaticDir:        getenv("STATIC_DIR"),
+		Version:          buildVersion(),
+		Commit:           commit,
+		Built:            built,
+		Auth:             authFromEnv(),
+		Pprof:            currentConf().Pprof,
+		Reporter:         reporter,
+		TrustedProxies:   trustedProxiesFromEnv(),
+		CORS:             corsFromEnv(),
+		CompactXML:       compactFromEnv(),
+		FeedPath:         feedPathFromEnv(),
+		BaseURL:          baseURL(),
+	}
+}
+
+// The CORS settings from the config file, with CORS_ORIGINS (comma
+// separated) as the origins if it doesn't have any
+func corsFromEnv() server.CORS {
+	cors := currentConf().CORS
+	if len(cors.Origins) == 0 && getenv("CORS_ORIGINS") != "" {
+		for _, o := range strings.Split(getenv("CORS_ORIGINS"), ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				cors.Origins = append(cors.Origins, o)
+			}
+		}
+	}
+	return cors
+}
+
+// Whether feeds are written compact rather than indented: FEED_XML (or a
+// flag) being "compact" or "pretty", the default
+func parseXMLFormat(v string) (bool, error) {
+	switch v {
+	case "", "pretty":
+		return false, nil
+	case "compact":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid FEED_XML %q: want pretty or compact", v)
+}
+
+func compactFromEnv() bool {
+	compact, err := parseXMLFormat(getenv("FEED_XML"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return compact
+}
+
+// Where the default show's feed is served: FEED_PATH, one of the paths it
+// can be found at, or "/rss.xml" by default
+func parseFeedPath(v string) (string, error) {
+	if v == "" {
+		return server.FeedPaths[0], nil
+	}
+	for _, p := range server.FeedPaths {
+		if v == p {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid FEED_PATH %q: want one of %s", v, strings.Join(server.FeedPaths, ", "))
+}
+
+func feedPathFromEnv() string {
+	p, err := parseFeedPath(getenv("FEED_PATH"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return p
+}
+
+// The URL fanatic is public at, without a trailing slash: base_url from the
+// config file, or BASE_URL. Empty if it isn't known
+func baseURL() string {
+	base := currentConf().BaseURL
+	if base == "" {
+		base = getenv("BASE_URL")
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// Check the base URL is one links can be made from
+func checkBaseURL() error {
+	base := baseURL()
+	if base == "" {
+		return nil
+	}
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid base URL %q: want one like https://fanatic.example.com", base)
+	}
+	return nil
+}
+
+// The reverse proxies in TRUSTED_PROXIES, whose X-Forwarded-For is believed
+func trustedProxiesFromEnv() server.TrustedProxies {
+	t, err := server.ParseTrustedProxies(getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %s", err)
+	}
+	return t
+}
+
+// The credentials from the config file, with ADMIN_TOKEN as the token if
+// it doesn't have one
+func authFromEnv() server.Auth {
+	auth := currentConf().Auth
+	if auth.Token == "" {
+		auth.Token = getenv("ADMIN_TOKEN")
+	}
+	return auth
+}
+
+// Pick the refresh schedule configured in the environment: a cron spec, the
+// broadcast slot or, by default, a fixed interval
+func scheduleFromEnv() (server.Schedule, error) {
+	if spec := getenv("REFRESH_CRON"); spec != "" {
+		cs, err := server.ParseCron(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REFRESH_CRON: %s", err)
+		}
+		return cs, nil
+	}
+
+	if slot := getenv("BROADCAST_TIME"); slot != "" {
+		day, start, err := server.ParseBroadcastTime(slot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROADCAST_TIME: %s", err)
+		}
+		tz := getenv("BROADCAST_TZ")
+		if tz == "" {
+			tz = "America/Los_Angeles"
+		}
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROADCAST_TZ: %s", err)
+		}
+		bs := server.BroadcastSchedule{
+			Weekday:  day,
+			Start:    start,
+			Location: loc,
+			Length:   envDuration("BROADCAST_LENGTH", 2*time.Hour),
+			Window:   envDuration("BROADCAST_WINDOW", 12*time.Hour),
+			Fast:     envDuration("BROADCAST_POLL_INTERVAL", 15*time.Minute),
+			Slow:     envDuration("REFRESH_INTERVAL", time.Hour),
+		}
+		// Otherwise KCRW would be polled nonstop
+		durations := []struct {
+			name string
+			d    time.Duration
+		}{
+			{"BROADCAST_LENGTH", bs.Length},
+			{"BROADCAST_WINDOW", bs.Window},
+			{"BROADCAST_POLL_INTERVAL", bs.Fast},
+			{"REFRESH_INTERVAL", bs.Slow},
+		}
+		for _, d := range durations {
+			if d.d <= 0 {
+				return nil, fmt.Errorf("%s must be positive", d.name)
+			}
+		}
+		return bs, nil
+	}
+
+	is := server.IntervalSchedule{
+		Interval: envDuration("REFRESH_INTERVAL", time.Hour),
+		Jitter:   envDuration("REFRESH_JITTER", 0),
+	}
+	if is.Interval <= 0 {
+		return nil, errors.New("REFRESH_INTERVAL must be positive")
+	}
+	return is, nil
+}
+
+// The link checker as LINK_CHECK_INTERVAL and LINK_CHECK_CONCURRENCY say
+func linkCheckerFromEnv() server.LinkChecker {
+	return server.LinkChecker{
+		Interval:    envDuration("LINK_CHECK_INTERVAL", 24*time.Hour),
+		Concurrency: envInt("LINK_CHECK_CONCURRENCY", 4),
+		Transport:   kcrwTransport(true),
+	}
+}
+
+// The hub to ping when the feed at topic changes, from WEBSUB_HUB. Feeds
+// without a public URL can't be announced
+func webSub(topic string) server.WebSub {
+	if topic == "" {
+		return server.WebSub{}
+	}
+	return server.WebSub{Hub: getenv("WEBSUB_HUB"), Topic: topic}
+}
+
+// Podping settings for the feed at url, or nil unless PODPING is set and
+// the feed has a public URL
+func podping(url string) *server.Podping {
+	if getenv("PODPING") == "" || url == "" {
+		return nil
+	}
+	return &server.Podping{
+		URL:   getenv("PODPING_URL"),
+		Token: getenv("PODPING_TOKEN"),
+		Feed:  url,
+	}
+}
+
+// Check WebSub and Podping have what they need to announce the default
+// show's feed
+func checkAnnounceEnv(feedURL string) error {
+	if getenv("WEBSUB_HUB") != "" && feedURL == "" {
+		return errors.New("WEBSUB_HUB needs FEED_URL set to the feed's public URL")
+	}
+	if getenv("PODPING") != "" && (getenv("PODPING_TOKEN") == "" || feedURL == "") {
+		return errors.New("PODPING needs PODPING_TOKEN and FEED_URL")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package feed
+
+import (
+	"github.com/djl/fanatic/scraper"
+)
+
+const historyNS = "http://purl.org/syndication/history/1.0"
+
+// Page places a document in a feed split into RFC 5005 archives. The zero
+// Page is a complete feed
+type Page struct {
+	// Archive marks an archive document, whose episodes never change.
+	// Self is its URL
+	Archive bool
+	Self    string
+
+	// Current is the subscription feed's URL, PrevArchive the next older
+	// archive's and NextArchive the next newer one's, each if there is one
+	Current     string
+	PrevArchive string
+	NextArchive string
+}
+
+// Paginate splits episodes into the subscription feed's and archive pages
+// of size episodes each, the oldest archive first. Archives are always
+// full, so they never change as new episodes come along; the subscription
+// feed gets the newest episodes, between size and 2*size-1 of them. A size
+// of 0 means no archives
+func Paginate(episodes []scraper.Episode, size int) (current []scraper.Episode, archives [][]scraper.Episode) {
+	n := len(episodes)
+	if size <= 0 || n < 2*size {
+		return episodes, nil
+	}
+
+	sorted := NewestFirst(episodes)
+	pages := (n - size) / size
+	for p := 1; p <= pages; p++ {
+		archives = append(archives, sorted[n-p*size:n-(p-1)*size])
+	}
+	return sorted[:n-pages*size], archives
+}
+
+// Links for the page, in addition to the self and hub links
+func (p Page) links() []AtomLink {
+	var links []AtomLink
+	if p.Current != "" && p.Archive {
+		links = append(links, AtomLink{Rel: "current", Href: p.Current, Type: "application/rss+xml"})
+	}
+	if p.PrevArchive != "" {
+		// next is the paged feed (RFC 5005 section 3) way of saying the
+		// same, for clients that only follow that
+		links = append(links,
+			AtomLink{Rel: "prev-archive", Href: p.PrevArchive, Type: "application/rss+xml"},
+			AtomLink{Rel: "next", Href: p.PrevArchive, Type: "application/rss+xml"},
+		)
+	}
+	if p.NextArchive != "" {
+		links = append(links, AtomLink{Rel: "next-archive", Href: p.NextArchive, Type: "application/rss+xml"})
+	}
+	return links
+}
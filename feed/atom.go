@@ -0,0 +1,145 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// The feed as Atom (RFC 4287), for readers that would rather have it
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	NS       string      `xml:"xmlns,attr"`
+	Lang     string      `xml:"xml:lang,attr,omitempty"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Rights   string      `xml:"rights,omitempty"`
+	Logo     string      `xml:"logo,omitempty"`
+	Authors  []atomName  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomName struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Links     []atomLink `xml:"link"`
+	Summary   *atomText  `xml:"summary"`
+	Content   *atomText  `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+var uuidRE = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// An entry's id, which has to be an IRI: its guid if that's a URL or a
+// urn:uuid: if it's a UUID
+func atomID(g GUID) string {
+	if g.IsPermaLink {
+		return g.ID
+	}
+	if uuidRE.MatchString(g.ID) {
+		return "urn:uuid:" + g.ID
+	}
+	if u, err := url.Parse(g.ID); err == nil && u.Scheme != "" {
+		return g.ID
+	}
+	return "urn:fanatic:" + url.PathEscape(g.ID)
+}
+
+// BuildAtom renders the episodes as an Atom feed
+func (b *FeedBuilder) BuildAtom(episodes []scraper.Episode) (string, error) {
+	episodes = b.ordered(episodes)
+	updated := b.Built
+	for _, e := range episodes {
+		if e.PubDate.After(updated) {
+			updated = e.PubDate
+		}
+	}
+	// Atom feeds have to say when they were updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	id := b.Self
+	if id == "" {
+		id = b.Link
+	}
+	f := atomFeed{
+		NS:       "http://www.w3.org/2005/Atom",
+		Lang:     b.Language,
+		Title:    b.Title,
+		Subtitle: b.Description,
+		ID:       id,
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Rights:   b.Copyright,
+		Logo:     b.Image,
+		Links:    []atomLink{{Rel: "alternate", Href: b.Link, Type: "text/html"}},
+	}
+	if b.Self != "" {
+		f.Links = append(f.Links, atomLink{Rel: "self", Href: b.Self, Type: "application/atom+xml"})
+	}
+	if b.Hub != "" && b.Self != "" {
+		f.Links = append(f.Links, atomLink{Rel: "hub", Href: b.Hub})
+	}
+	for _, p := range b.Persons {
+		f.Authors = append(f.Authors, atomName{p.Name})
+	}
+	if len(f.Authors) == 0 {
+		// Feeds need an author, if only for each entry
+		f.Authors = []atomName{{b.Title}}
+	}
+
+	for _, e := range episodes {
+		url, mediaType := b.enclosure(e)
+		published := e.PubDate.UTC().Format(time.RFC3339)
+		entry := atomEntry{
+			Title:     e.Title,
+			ID:        atomID(guid(e)),
+			Updated:   published,
+			Published: published,
+			Links:     []atomLink{{Rel: "enclosure", Href: url, Type: mediaType, Length: e.Length}},
+		}
+		if e.Link != "" {
+			entry.Links = append(entry.Links, atomLink{Rel: "alternate", Href: e.Link, Type: "text/html"})
+		}
+		if d := descriptionHTML(e); d != "" {
+			entry.Content = &atomText{Type: "html", Text: d}
+			entry.Summary = &atomText{Type: "text", Text: descriptionText(e)}
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if !b.Compact {
+		enc.Indent("", "  ")
+	}
+	if err := enc.Encode(f); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
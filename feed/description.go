@@ -0,0 +1,126 @@
+package feed
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Apple's limit on itunes:summary
+const maxSummary = 4000
+
+// Descriptions with tags like these are taken to be HTML already
+var htmlRE = regexp.MustCompile(`(?i)<(p|br|a|b|i|em|strong|ul|ol|li|div|span|h[1-6])\b[^>]*>`)
+
+// Blank lines separate paragraphs of plain text
+var paragraphRE = regexp.MustCompile(`\n\s*\n`)
+
+// The item's description as HTML: the episode's description (made into
+// paragraphs if it's plain text) followed by its tracklist. "" if it has
+// neither
+func descriptionHTML(e scraper.Episode) string {
+	var b strings.Builder
+	if d := strings.TrimSpace(e.Description); d != "" {
+		if htmlRE.MatchString(d) {
+			b.WriteString(d)
+		} else {
+			for _, para := range paragraphRE.Split(d, -1) {
+				para = strings.TrimSpace(para)
+				if para == "" {
+					continue
+				}
+				b.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(para), "\n", "<br>") + "</p>")
+			}
+		}
+	}
+	if len(e.Tracklist) > 0 {
+		b.WriteString("<p>Tracklist:</p><ol>")
+		for _, t := range e.Tracklist {
+			b.WriteString("<li>" + html.EscapeString(t) + "</li>")
+		}
+		b.WriteString("</ol>")
+	}
+	return b.String()
+}
+
+// Elements starting a new line when HTML is turned into text, and those
+// ending a paragraph
+var (
+	lineElements = map[string]bool{"br": true, "li": true}
+	paraElements = map[string]bool{
+		"p": true, "div": true, "ul": true, "ol": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	}
+)
+
+// The description as plain text, for itunes:summary and apps that don't
+// render HTML. Numbered list items are numbered
+func descriptionText(e scraper.Episode) string {
+	var b strings.Builder
+	var lists []int // the next number of each list open, 0 if unnumbered
+	z := xhtml.NewTokenizer(strings.NewReader(descriptionHTML(e)))
+	for {
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			return summary(b.String())
+		case xhtml.TextToken:
+			b.Write(z.Text())
+		case xhtml.StartTagToken, xhtml.EndTagToken, xhtml.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch {
+			case tt == xhtml.EndTagToken && paraElements[tag]:
+				b.WriteString("\n\n")
+			case paraElements[tag], lineElements[tag] && tt != xhtml.EndTagToken:
+				b.WriteString("\n")
+			}
+			switch {
+			case tag == "ol" || tag == "ul":
+				if tt == xhtml.StartTagToken {
+					n := 0
+					if tag == "ol" {
+						n = 1
+					}
+					lists = append(lists, n)
+				} else if tt == xhtml.EndTagToken && len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+			case tag == "li" && tt == xhtml.StartTagToken && len(lists) > 0:
+				if n := lists[len(lists)-1]; n > 0 {
+					b.WriteString(strconv.Itoa(n) + ". ")
+					lists[len(lists)-1]++
+				}
+			}
+		}
+	}
+}
+
+// Tidy text from descriptionText: lines trimmed, no blank lines at the
+// start or end or more than one in a row, and cut short to fit itunes:summary
+func summary(s string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	s = strings.Join(lines, "\n")
+	if r := []rune(s); len(r) > maxSummary {
+		s = string(r[:maxSummary-1]) + "…"
+	}
+	return s
+}
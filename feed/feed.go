@@ -0,0 +1,263 @@
+// Package feed renders scraped episodes as a podcast RSS feed.
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// FeedBuilder holds the channel metadata for a feed
+type FeedBuilder struct {
+	Title       string
+	Description string
+	Language    string
+	Copyright   string
+	Link        string
+
+	// Self is the feed's own URL, and Hub the WebSub hub subscribers
+	// should use for updates. Both are needed to advertise the hub
+	Self string
+	Hub  string
+
+	// Image is the URL of the podcast's artwork, if it has any
+	Image string
+
+	// Podcasting 2.0 tags. GUID is the podcast:guid, worked out from Self
+	// if empty. Locked, if set, is "yes" or "no", with LockedOwner the
+	// email address to prove ownership with. Funding and Persons list
+	// where to support the show and who's on it
+	GUID        string
+	Locked      string
+	LockedOwner string
+	Funding     []Funding
+	Persons     []Person
+
+	// Generator names what built the feed, e.g. "fanatic v1.2.0", in a
+	// comment at the top. Nothing is written if empty
+	Generator string
+
+	// Compact leaves out the indentation, for smaller feeds
+	Compact bool
+
+	// OldestFirst lists episodes oldest first, for listening through from
+	// the start, rather than newest first
+	OldestFirst bool
+
+	// Built is when the feed was built, written as its lastBuildDate if
+	// set. TTL tells readers how long they can cache it, rounded up to
+	// whole minutes; none if zero
+	Built time.Time
+	TTL   time.Duration
+
+	// Transcript, if set, gives the URL and media type of an episode's
+	// transcript, "" if it hasn't one
+	Transcript func(scraper.Episode) (string, string)
+
+	// Enclosure, if set, gives the URL of an episode's audio (e.g. on
+	// fanatic's own /media/ or a mirror) in place of its MP3
+	Enclosure func(scraper.Episode) string
+}
+
+// New returns a FeedBuilder for Henry Rollins' show, linking to the show
+// page at link
+func New(link string) *FeedBuilder {
+	return &FeedBuilder{
+		Title:       "Henry Rollins - KCRW",
+		Description: "Henry Rollins hosts a mix of all kinds, from all over and all time.",
+		Language:    "EN",
+		Copyright:   "KCRW",
+		Link:        link,
+		Persons:     []Person{{Name: "Henry Rollins", Role: "host"}},
+	}
+}
+
+// Build renders the episodes as RSS
+func (b *FeedBuilder) Build(episodes []scraper.Episode) (string, error) {
+	return b.BuildPage(episodes, Page{})
+}
+
+// BuildPage renders the episodes as one page of a feed split into archives
+func (b *FeedBuilder) BuildPage(episodes []scraper.Episode, page Page) (string, error) {
+	channel := &Channel{
+		Title:       b.Title,
+		Description: b.Description,
+		Language:    b.Language,
+		Copyright:   b.Copyright,
+		Link:        b.Link,
+	}
+	if !b.Built.IsZero() {
+		built := PubDate(b.Built)
+		channel.BuildDate = &built
+	}
+	if b.TTL > 0 {
+		channel.TTL = int((b.TTL + time.Minute - 1) / time.Minute)
+	}
+	if b.Image != "" {
+		channel.Image = &ItunesImage{Href: b.Image}
+	}
+
+	self := b.Self
+	if page.Archive {
+		self = page.Self
+		channel.Archive = &struct{}{}
+	}
+	if self != "" {
+		channel.AtomLinks = append(channel.AtomLinks, AtomLink{Rel: "self", Href: self, Type: "application/rss+xml"})
+	}
+	if b.Hub != "" && b.Self != "" && !page.Archive {
+		channel.AtomLinks = append(channel.AtomLinks, AtomLink{Rel: "hub", Href: b.Hub})
+	}
+	channel.AtomLinks = append(channel.AtomLinks, page.links()...)
+
+	channel.PodcastGUID = b.GUID
+	if channel.PodcastGUID == "" && b.Self != "" {
+		channel.PodcastGUID = PodcastGUID(b.Self)
+	}
+	if b.Locked != "" {
+		channel.Locked = &Locked{Value: b.Locked, Owner: b.LockedOwner}
+	}
+	channel.Funding, channel.Persons = b.Funding, b.Persons
+
+	transcripts := false
+	for _, episode := range b.ordered(episodes) {
+		url, mediaType := b.enclosure(episode)
+		season := 0
+		if episode.Number > 0 {
+			season = episode.PubDate.Year()
+		}
+		var image *ItunesImage
+		thumbnail := b.Image
+		if episode.Image != "" {
+			image = &ItunesImage{Href: episode.Image}
+			thumbnail = episode.Image
+		}
+		var transcript *Transcript
+		if b.Transcript != nil {
+			if u, t := b.Transcript(episode); u != "" {
+				transcript = &Transcript{URL: u, Type: t}
+				transcripts = true
+			}
+		}
+		var description *CDATA
+		if d := descriptionHTML(episode); d != "" {
+			description = &CDATA{d}
+		}
+		item := &Item{
+			Title:       episode.Title,
+			GUID:        guid(episode),
+			Duration:    Duration(episode.Duration),
+			Episode:     episode.Number,
+			Season:      season,
+			Image:       image,
+			Transcript:  transcript,
+			Description: description,
+			Summary:     descriptionText(episode),
+			Enclosure: &Enclosure{
+				URL:    url,
+				Length: strconv.FormatInt(episode.Length, 10),
+				Type:   mediaType,
+			},
+			MediaContent: &MediaContent{
+				URL:      url,
+				FileSize: episode.Length,
+				Type:     mediaType,
+				Medium:   "audio",
+				Duration: int64(episode.Duration.Seconds()),
+			},
+			PubDate: PubDate(episode.PubDate),
+		}
+		if thumbnail != "" {
+			item.MediaThumbnail = &MediaThumbnail{URL: thumbnail}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	rss := RSS{Itunes: itunesNS, Media: mediaNS, Version: "2.0", Channel: channel}
+	if b.Generator != "" {
+		// Comments can't hold "--"
+		rss.Comment = " generated by " + strings.ReplaceAll(b.Generator, "--", "- -") + " "
+	}
+	if len(channel.AtomLinks) > 0 {
+		rss.Atom = atomNS
+	}
+	if page.Archive {
+		rss.History = historyNS
+	}
+	if channel.PodcastGUID != "" || channel.Locked != nil || len(channel.Funding) > 0 || len(channel.Persons) > 0 || transcripts {
+		rss.Podcast = podcastNS
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if !b.Compact {
+		enc.Indent("", "  ")
+	}
+	if err := enc.Encode(rss); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// The episodes in the order the feed lists them
+func (b *FeedBuilder) ordered(episodes []scraper.Episode) []scraper.Episode {
+	episodes = NewestFirst(episodes)
+	if b.OldestFirst {
+		for i, j := 0, len(episodes)-1; i < j; i, j = i+1, j-1 {
+			episodes[i], episodes[j] = episodes[j], episodes[i]
+		}
+	}
+	return episodes
+}
+
+// The URL and media type of an episode's audio
+func (b *FeedBuilder) enclosure(e scraper.Episode) (string, string) {
+	mediaType := e.MediaType
+	if mediaType == "" {
+		mediaType = scraper.DefaultMediaType
+	}
+	if b.Enclosure != nil {
+		return b.Enclosure(e), mediaType
+	}
+	return e.MP3, mediaType
+}
+
+// NewestFirst returns a copy of the episodes sorted by when they were
+// published, newest first, whatever order they were found in. Episodes
+// published at the same time keep their order
+func NewestFirst(episodes []scraper.Episode) []scraper.Episode {
+	sorted := append([]scraper.Episode(nil), episodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PubDate.After(sorted[j].PubDate)
+	})
+	return sorted
+}
+
+// An episode's guid: its GUID, or UUID if it hasn't been given one, which
+// is a permalink if it's the episode's page. Other URLs (e.g. an imported
+// episode's MP3) only identify it
+func guid(e scraper.Episode) GUID {
+	id := e.GUID
+	if id == "" {
+		id = e.UUID
+	}
+	u, err := url.Parse(id)
+	return GUID{ID: id, IsPermaLink: id == e.Link && err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""}
+}
+
+// A feed's lastBuildDate, which changes every time it's built
+var buildDateRE = regexp.MustCompile(`<lastBuildDate>[^<]*</lastBuildDate>`)
+
+// SameContent reports whether two feeds are the same apart from when they
+// were built
+func SameContent(a, b string) bool {
+	return buildDateRE.ReplaceAllString(a, "") == buildDateRE.ReplaceAllString(b, "")
+}
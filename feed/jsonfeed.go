@@ -0,0 +1,106 @@
+package feed
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// The feed as JSON Feed 1.1 (https://jsonfeed.org/version/1.1)
+type jsonFeed struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url,omitempty"`
+	FeedURL     string       `json:"feed_url,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Icon        string       `json:"icon,omitempty"`
+	Language    string       `json:"language,omitempty"`
+	Authors     []jsonAuthor `json:"authors,omitempty"`
+	Hubs        []jsonHub    `json:"hubs,omitempty"`
+	Items       []jsonItem   `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name   string `json:"name"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type jsonItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	ContentText   string           `json:"content_text,omitempty"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published"`
+	Attachments   []jsonAttachment `json:"attachments"`
+}
+
+type jsonAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size_in_bytes,omitempty"`
+	Duration int64  `json:"duration_in_seconds,omitempty"`
+}
+
+// BuildJSON renders the episodes as a JSON Feed
+func (b *FeedBuilder) BuildJSON(episodes []scraper.Episode) (string, error) {
+	f := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       b.Title,
+		HomePageURL: b.Link,
+		FeedURL:     b.Self,
+		Description: b.Description,
+		Icon:        b.Image,
+		Language:    b.Language,
+		Items:       []jsonItem{},
+	}
+	for _, p := range b.Persons {
+		f.Authors = append(f.Authors, jsonAuthor{Name: p.Name, URL: p.Href, Avatar: p.Img})
+	}
+	if b.Hub != "" && b.Self != "" {
+		f.Hubs = []jsonHub{{Type: "WebSub", URL: b.Hub}}
+	}
+
+	for _, e := range b.ordered(episodes) {
+		url, mediaType := b.enclosure(e)
+		item := jsonItem{
+			ID:            guid(e).ID,
+			URL:           e.Link,
+			Title:         e.Title,
+			ContentHTML:   descriptionHTML(e),
+			Image:         e.Image,
+			DatePublished: e.PubDate.Format(time.RFC3339),
+			Attachments: []jsonAttachment{{
+				URL:      url,
+				MimeType: mediaType,
+				Size:     e.Length,
+				Duration: int64(e.Duration.Seconds()),
+			}},
+		}
+		// Items need content of some sort
+		if item.ContentHTML == "" {
+			item.ContentText = e.Title
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	var data []byte
+	var err error
+	if b.Compact {
+		data, err = json.Marshal(f)
+	} else {
+		data, err = json.MarshalIndent(f, "", "  ")
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
@@ -0,0 +1,61 @@
+package feed
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+)
+
+// The Podcasting 2.0 namespace, https://podcastindex.org/namespace/1.0
+const podcastNS = "https://podcastindex.org/namespace/1.0"
+
+// Every feed's podcast:guid is a UUIDv5 of its URL in this namespace
+var podcastGUIDNamespace = [16]byte{0xea, 0xd4, 0xc2, 0x36, 0xbf, 0x58, 0x58, 0xc6, 0xa2, 0xc6, 0xa6, 0xb2, 0x8d, 0x12, 0x8c, 0xb6}
+
+// Person is someone on the show, e.g. its host, as a podcast:person. Href
+// is a page about them and Img a picture of them
+type Person struct {
+	Name string `xml:",chardata" json:"name"`
+	Role string `xml:"role,attr,omitempty" json:"role"`
+	Href string `xml:"href,attr,omitempty" json:"href"`
+	Img  string `xml:"img,attr,omitempty" json:"img"`
+}
+
+// Funding is somewhere listeners can support the show, as a
+// podcast:funding
+type Funding struct {
+	URL  string `xml:"url,attr" json:"url"`
+	Text string `xml:",chardata" json:"text"`
+}
+
+// Transcript links to an episode's transcript, as a podcast:transcript.
+// Type is its media type, e.g. text/vtt
+type Transcript struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Locked is a podcast:locked, "yes" to ask other podcast hosts not to
+// import the feed. Owner is the email address to prove ownership with
+type Locked struct {
+	Value string `xml:",chardata"`
+	Owner string `xml:"owner,attr,omitempty"`
+}
+
+// PodcastGUID returns the podcast:guid for the feed at feedURL, which is
+// the same wherever it's worked out
+func PodcastGUID(feedURL string) string {
+	name := feedURL
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	name = strings.TrimRight(name, "/")
+
+	h := sha1.New()
+	h.Write(podcastGUIDNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,156 @@
+package feed
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+	atomNS   = "http://www.w3.org/2005/Atom"
+	mediaNS  = "http://search.yahoo.com/mrss/"
+	rfc2822  = "Mon, 02 Jan 2006 15:04:05 -0700"
+)
+
+// RSS is an RSS 2.0 document with the iTunes, Atom, feed history (RFC
+// 5005), Podcasting 2.0 and Media RSS extensions the feed
+// uses. Namespaced elements are written with literal prefixes, so the
+// xmlns attributes here must declare them
+type RSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Itunes  string   `xml:"xmlns:itunes,attr"`
+	Atom    string   `xml:"xmlns:atom,attr,omitempty"`
+	History string   `xml:"xmlns:fh,attr,omitempty"`
+	Podcast string   `xml:"xmlns:podcast,attr,omitempty"`
+	Media   string   `xml:"xmlns:media,attr"`
+	Version string   `xml:"version,attr"`
+	Comment string   `xml:",comment"`
+	Channel *Channel `xml:"channel"`
+}
+
+// Channel is the podcast
+type Channel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Copyright   string       `xml:"copyright"`
+	Language    string       `xml:"language"`
+	Description string       `xml:"description"`
+	BuildDate   *PubDate     `xml:"lastBuildDate"`
+	TTL         int          `xml:"ttl,omitempty"`
+	Image       *ItunesImage `xml:"itunes:image"`
+	AtomLinks   []AtomLink   `xml:"atom:link"`
+	PodcastGUID string       `xml:"podcast:guid,omitempty"`
+	Locked      *Locked      `xml:"podcast:locked"`
+	Funding     []Funding    `xml:"podcast:funding"`
+	Persons     []Person     `xml:"podcast:person"`
+	Archive     *struct{}    `xml:"fh:archive"`
+	Items       []*Item      `xml:"item"`
+}
+
+// ItunesImage is the podcast's artwork
+type ItunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// AtomLink is an atom:link, e.g. to the feed itself or its WebSub hub
+type AtomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// Item is an episode
+type Item struct {
+	Title    string   `xml:"title"`
+	GUID     GUID     `xml:"guid"`
+	PubDate  PubDate  `xml:"pubDate"`
+	Duration Duration `xml:"itunes:duration,omitempty"`
+
+	// Episode is the episode's number, and Season the year it's from
+	Episode int `xml:"itunes:episode,omitempty"`
+	Season  int `xml:"itunes:season,omitempty"`
+
+	// Image is the episode's own artwork, if it has any
+	Image *ItunesImage `xml:"itunes:image"`
+
+	// Description is HTML, so it's wrapped in CDATA. Summary is the same
+	// as plain text
+	Description *CDATA `xml:"description,omitempty"`
+	Summary     string `xml:"itunes:summary,omitempty"`
+
+	Transcript *Transcript `xml:"podcast:transcript"`
+	Enclosure  *Enclosure  `xml:"enclosure"`
+
+	// The enclosure and artwork again, for apps that only read Media RSS
+	MediaContent   *MediaContent   `xml:"media:content"`
+	MediaThumbnail *MediaThumbnail `xml:"media:thumbnail"`
+}
+
+// MediaContent is an episode's audio as a media:content. FileSize is in
+// bytes and Duration in seconds, each left out if unknown
+type MediaContent struct {
+	URL      string `xml:"url,attr"`
+	FileSize int64  `xml:"fileSize,attr,omitempty"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	Duration int64  `xml:"duration,attr,omitempty"`
+}
+
+// MediaThumbnail is an episode's artwork as a media:thumbnail
+type MediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+// GUID is an item's guid. IsPermaLink is written either way, as readers
+// take a guid without it to be a URL
+type GUID struct {
+	ID          string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// CDATA is text written as a CDATA section, e.g. HTML
+type CDATA struct {
+	Text string `xml:",cdata"`
+}
+
+// Enclosure is an episode's audio. Length is in bytes, "0" if unknown
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// PubDate is written in RFC 2822 format
+type PubDate time.Time
+
+func (p PubDate) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(time.Time(p).Format(rfc2822), start)
+}
+
+// Duration is written as [H:]MM:SS
+type Duration time.Duration
+
+func (d Duration) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(formatDuration(time.Duration(d)), start)
+}
+
+func formatDuration(d time.Duration) string {
+	total := int(d.Seconds())
+	hours, minutes, seconds := total/3600, total%3600/60, total%60
+
+	var b strings.Builder
+	if hours > 0 {
+		b.WriteString(strconv.Itoa(hours) + ":")
+		if minutes < 10 {
+			b.WriteString("0")
+		}
+	}
+	b.WriteString(strconv.Itoa(minutes) + ":")
+	if seconds < 10 {
+		b.WriteString("0")
+	}
+	b.WriteString(strconv.Itoa(seconds))
+	return b.String()
+}
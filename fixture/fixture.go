@@ -0,0 +1,118 @@
+// Package fixture records HTTP responses to disk and replays them, so the
+// scraper can be run against a saved copy of KCRW without the network.
+package fixture
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var unsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Path returns where the response for a GET of u is stored under dir: a
+// file per host and path, e.g. www.kcrw.com/music_shows_henry-rollins.http
+func Path(dir string, u string) string {
+	host, rest := u, ""
+	if i := strings.Index(u, "://"); i >= 0 {
+		host = u[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?"); i >= 0 {
+		host, rest = host[:i], host[i:]
+	}
+
+	path, query := rest, ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		path, query = rest[:i], rest[i+1:]
+	}
+
+	name := unsafe.ReplaceAllString(strings.Trim(path, "/"), "_")
+	if name == "" {
+		name = "index"
+	}
+	if query != "" {
+		sum := sha1.Sum([]byte(query))
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(dir, unsafe.ReplaceAllString(host, "_"), name+".http")
+}
+
+// Recorder is an http.RoundTripper which saves every response it passes
+// on under Dir
+type Recorder struct {
+	Dir string
+
+	// Transport makes the real requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := r.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	dump, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	path := Path(r.Dir, req.URL.String())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, dump, 0644); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Replayer is an http.RoundTripper answering requests from responses saved
+// under Dir by a Recorder. Requests for anything not recorded get a 404
+type Replayer struct {
+	Dir string
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	dump, err := ioutil.ReadFile(Path(r.Dir, req.URL.String()))
+	if os.IsNotExist(err) {
+		body := fmt.Sprintf("no fixture for %s", req.URL)
+		return &http.Response{
+			Status:        "404 Not Found",
+			StatusCode:    http.StatusNotFound,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+}
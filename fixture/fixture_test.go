@@ -0,0 +1,63 @@
+package fixture_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djl/fanatic/fixture"
+	"github.com/djl/fanatic/scraper"
+)
+
+// The show page recorded in testdata/kcrw, scraped again from the
+// recording
+func TestReplayEpisodes(t *testing.T) {
+	s := scraper.New(scraper.DefaultURL)
+	s.Transport = &fixture.Replayer{Dir: "../testdata/kcrw"}
+	s.Media = scraper.NewMediaCache()
+
+	episodes, err := s.Episodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		number  int
+		pubDate time.Time
+		length  int64
+	}{
+		{763, time.Date(2023, 5, 21, 4, 0, 0, 0, time.UTC), 114240000},
+		{762, time.Date(2023, 5, 14, 4, 0, 0, 0, time.UTC), 114241920},
+		{761, time.Date(2023, 5, 7, 4, 0, 0, 0, time.UTC), 114239872},
+	}
+	if len(episodes) != len(want) {
+		t.Fatalf("got %d episodes, want %d", len(episodes), len(want))
+	}
+	for i, w := range want {
+		e := episodes[i]
+		if e.Number != w.number {
+			t.Errorf("episode %d: number %d, want %d", i, e.Number, w.number)
+		}
+		if !e.PubDate.Equal(w.pubDate) {
+			t.Errorf("episode %d: published %s, want %s", i, e.PubDate, w.pubDate)
+		}
+		if e.Length != w.length {
+			t.Errorf("episode %d: length %d, want %d (from probing the recorded MP3)", i, e.Length, w.length)
+		}
+		if e.Duration != 119*time.Minute {
+			t.Errorf("episode %d: duration %s, want 1h59m", i, e.Duration)
+		}
+		if e.MP3 == "" || e.UUID == "" || e.MediaType != scraper.DefaultMediaType {
+			t.Errorf("episode %d: incomplete %+v", i, e)
+		}
+	}
+}
+
+// Requests that weren't recorded fail rather than going to the network
+func TestReplayMissing(t *testing.T) {
+	s := scraper.New("https://www.kcrw.com/music/shows/not-recorded")
+	s.Transport = &fixture.Replayer{Dir: "../testdata/kcrw"}
+
+	if _, err := s.Episodes(); err == nil {
+		t.Fatal("scraping an unrecorded page succeeded")
+	}
+}
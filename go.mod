@@ -1,11 +1,19 @@
 module github.com/djl/fanatic
 
-go 1.16
+go 1.25.0
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
-	github.com/andybalholm/cascadia v1.3.2 // indirect
-	github.com/jbub/podcasts v0.2.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/tidwall/gjson v1.14.4
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.56.0
+	golang.org/x/sync v0.22.0
+	golang.org/x/text v0.40.0
+)
+
+require (
+	github.com/andybalholm/cascadia v1.3.2 // indirect
+	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 )
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// The parts of a podcast's RSS feed read by fanatic import
+type importedRSS struct {
+	Channel struct {
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			GUID        string `xml:"guid"`
+			Episode     int    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+			PubDate     string `xml:"pubDate"`
+			Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure   *struct {
+				URL    string `xml:"url,attr"`
+				Length string `xml:"length,attr"`
+				Type   string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// Date formats seen in the wild in RSS pubDates
+var pubDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+func parsePubDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range pubDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse date %q", s)
+}
+
+// Parse an itunes:duration, either seconds or [H:]MM:SS
+func parseItunesDuration(s string) time.Duration {
+	var secs int
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// An ID for an imported episode, which is used in paths (e.g.
+// /media/<uuid>.mp3) where its GUID, often a URL, can't be
+func importedUUID(guid string) string {
+	sum := sha1.Sum([]byte(guid))
+	return hex.EncodeToString(sum[:])
+}
+
+// Read the episodes from an RSS feed. Items without an enclosure are
+// skipped, and those without a GUID are known by their enclosure's URL
+func parseFeedEpisodes(data []byte) ([]scraper.Episode, error) {
+	var doc importedRSS
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("not a valid RSS document: %s", err)
+	}
+
+	var episodes []scraper.Episode
+	for i, item := range doc.Channel.Items {
+		if item.Enclosure == nil || item.Enclosure.URL == "" {
+			log.Printf("skipping item %d (%q), it has no enclosure", i+1, item.Title)
+			continue
+		}
+		date, err := parsePubDate(item.PubDate)
+		if err != nil {
+			return nil, fmt.Errorf("item %d (%q): %s", i+1, item.Title, err)
+		}
+
+		e := scraper.Episode{
+			Title:       strings.TrimSpace(item.Title),
+			Link:        strings.TrimSpace(item.Link),
+			MP3:         item.Enclosure.URL,
+			GUID:        strings.TrimSpace(item.GUID),
+			PubDate:     date,
+			Duration:    parseItunesDuration(item.Duration),
+			Description: strings.TrimSpace(item.Description),
+			MediaType:   item.Enclosure.Type,
+		}
+		// Apps already know the episode by this
+		if e.GUID == "" {
+			e.GUID = e.MP3
+		}
+		e.UUID = importedUUID(e.GUID)
+		if e.Number = item.Episode; e.Number == 0 {
+			e.Number = scraper.EpisodeNumber(e.Title)
+		}
+		e.Length, _ = strconv.ParseInt(item.Enclosure.Length, 10, 64)
+		episodes = append(episodes, e)
+	}
+	return episodes, nil
+}
+
+// Merge the items of an existing RSS feed (e.g. one generated before
+// moving to fanatic) into the show's feed saved in STATE_DIR, so its
+// history isn't lost. Episodes already saved are left as they are
+func cmdImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("f", "", "the RSS feed to import")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	dir := getenv("STATE_DIR")
+	if dir == "" {
+		return fmt.Errorf("STATE_DIR isn't set, there's nowhere to import to")
+	}
+	if *file == "" {
+		return fmt.Errorf("no feed given, use -f")
+	}
+	sh, err := selectedShow()
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	imported, err := parseFeedEpisodes(data)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", *file, err)
+	}
+
+	// A running serve would overwrite the import with its own episodes
+	// the next time it saves the feed
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	unlock, err := lockStateDir(dir, "import")
+	if err != nil {
+		return fmt.Errorf("%s; stop serve before importing", err)
+	}
+	defer unlock()
+
+	saved, err := readSavedFeed(dir, sh.Slug)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading saved feed: %s", err)
+	}
+
+	episodes := mergeEpisodes(saved.Episodes, imported)
+	xml, episodes, err := sh.paged(episodes)
+	if err != nil {
+		return fmt.Errorf("error generating XML: %s", err)
+	}
+	if err := writeSavedFeed(dir, sh.Slug, savedFeed{saved.Updated, xml, episodes}); err != nil {
+		return err
+	}
+	log.Printf("imported %d episodes, %d new", len(imported), len(episodes)-len(saved.Episodes))
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/server"
+)
+
+// An API Gateway proxy event. Both the REST API (version 1.0) and HTTP
+// API/function URL (version 2.0) payload formats are read into this
+type lambdaRequest struct {
+	Version string `json:"version"`
+
+	// 1.0
+	HTTPMethod        string              `json:"httpMethod"`
+	Path              string              `json:"path"`
+	MultiValueQuery   map[string][]string `json:"multiValueQueryStringParameters"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+
+	// 2.0
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	Headers        map[string]string `json:"headers"`
+	Body           string            `json:"body"`
+	IsBase64       bool              `json:"isBase64Encoded"`
+	RequestContext struct {
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+type lambdaResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64          bool                `json:"isBase64Encoded"`
+}
+
+// Turn a proxy event into the equivalent http.Request
+func (e *lambdaRequest) httpRequest() (*http.Request, error) {
+	body := []byte(e.Body)
+	if e.IsBase64 {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	u := &url.URL{}
+	var method, remote string
+	if e.Version == "2.0" {
+		method = e.RequestContext.HTTP.Method
+		remote = e.RequestContext.HTTP.SourceIP
+		u.Path = e.RawPath
+		u.RawQuery = e.RawQueryString
+	} else {
+		method = e.HTTPMethod
+		remote = e.RequestContext.Identity.SourceIP
+		u.Path = e.Path
+		u.RawQuery = url.Values(e.MultiValueQuery).Encode()
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range e.MultiValueHeaders {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	for k, v := range e.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if len(e.Cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	req.Host = req.Header.Get("Host")
+	req.RemoteAddr = remote
+	return req, nil
+}
+
+// Whether a response body can be returned as-is rather than base64 encoded
+func isText(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "xml") ||
+		strings.Contains(contentType, "json")
+}
+
+// Serve one proxy event with handler
+func serveLambda(handler http.Handler, e *lambdaRequest) (*lambdaResponse, error) {
+	req, err := e.httpRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	res := rec.Result()
+
+	out := &lambdaResponse{StatusCode: res.StatusCode}
+	if e.Version == "2.0" {
+		out.Headers = map[string]string{}
+		for k, vs := range res.Header {
+			if k == "Set-Cookie" {
+				out.Cookies = vs
+				continue
+			}
+			out.Headers[k] = strings.Join(vs, ",")
+		}
+	} else {
+		out.MultiValueHeaders = res.Header
+	}
+
+	body := rec.Body.Bytes()
+	if isText(res.Header.Get("Content-Type")) {
+		out.Body = string(body)
+	} else {
+		out.Body = base64.StdEncoding.EncodeToString(body)
+		out.IsBase64 = true
+	}
+	return out, nil
+}
+
+// Make sure the show holds a feed no older than maxAge, using the copy
+// cached in the bucket if it's fresh enough and regenerating it (and
+// updating the cache) otherwise
+func ensureFresh(sh server.Show, b *bucket, maxAge time.Duration) {
+	state, key := sh.State, "fanatic-cache-"+sh.Slug+".json"
+	if time.Since(state.LastUpdated()) < maxAge {
+		return
+	}
+
+	if b != nil {
+		data, err := b.get(key)
+		if err != nil && err != errNoSuchKey {
+			log.Printf("error reading cached feed: %s", err)
+		}
+		var c savedFeed
+		if err == nil && json.Unmarshal(data, &c) == nil && time.Since(c.Updated) < maxAge {
+			state.Set(c.XML, c.Episodes, c.Updated)
+			return
+		}
+	}
+
+	_, err := state.Refresh()
+	// Lambda freezes the function once it's answered
+	announcing.Wait()
+	if err != nil {
+		log.Printf("error generating XML: %s", err)
+		return
+	}
+	if b == nil {
+		return
+	}
+
+	xml, episodes, _ := state.Get()
+	data, err := json.Marshal(savedFeed{state.LastUpdated(), xml, episodes})
+	if err == nil {
+		err = b.put(key, data, "application/json", "")
+	}
+	if err != nil {
+		log.Printf("error caching feed: %s", err)
+	}
+}
+
+// Handle requests as an AWS Lambda function behind API Gateway or a
+// function URL, talking to the Lambda runtime API directly. The generated
+// feed is kept in S3_BUCKET (when set) so cold starts don't need to scrape
+func cmdLambda(args []string) error {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		return fmt.Errorf("AWS_LAMBDA_RUNTIME_API is not set, not running in Lambda?")
+	}
+	base := "http://" + api + "/2018-06-01/runtime"
+
+	var b *bucket
+	if getenv("S3_BUCKET") != "" {
+		var err error
+		if b, err = bucketFromEnv(); err != nil {
+			return err
+		}
+	}
+
+	maxAge := envDuration("REFRESH_INTERVAL", time.Hour)
+	configured, err := configuredShows()
+	if err != nil {
+		return err
+	}
+	shows := newShows(configured)
+	opts := optionsFromEnv()
+	if opts.LandingTemplate, err = landingTemplate(); err != nil {
+		return err
+	}
+	handler := server.NewHandler(shows, opts)
+
+	for {
+		res, err := http.Get(base + "/invocation/next")
+		if err != nil {
+			return err
+		}
+		event, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		id := res.Header.Get("Lambda-Runtime-Aws-Request-Id")
+
+		for _, sh := range refreshOrder(configured, shows) {
+			ensureFresh(sh, b, maxAge)
+		}
+
+		var out *lambdaResponse
+		var e lambdaRequest
+		if err = json.Unmarshal(event, &e); err == nil {
+			out, err = serveLambda(handler, &e)
+		}
+
+		path, payload := "/invocation/"+id+"/response", interface{}(out)
+		if err != nil {
+			log.Printf("error handling invocation %s: %s", id, err)
+			path = "/invocation/" + id + "/error"
+			payload = map[string]string{"errorMessage": err.Error(), "errorType": "InvalidEvent"}
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		res, err = http.Post(base+path, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// An address serve listens on, one of LISTEN's comma separated list: a TCP
+// address or unix:<path>, prefixed with redirect: to redirect to HTTPS
+// from it, or http: to serve plain HTTP even when HTTPS is set up
+type listenAddr struct {
+	addr     string
+	redirect bool
+	plain    bool
+}
+
+func (a listenAddr) String() string {
+	switch {
+	case a.redirect:
+		return "redirect:" + a.addr
+	case a.plain:
+		return "http:" + a.addr
+	}
+	return a.addr
+}
+
+// Parse a LISTEN list. Redirecting needs HTTPS set up
+func parseListen(spec string, secure bool) ([]listenAddr, error) {
+	var addrs []listenAddr
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var a listenAddr
+		switch {
+		case strings.HasPrefix(entry, "redirect:"):
+			a = listenAddr{addr: strings.TrimPrefix(entry, "redirect:"), redirect: true}
+			if !secure {
+				return nil, fmt.Errorf("can't redirect to HTTPS from %s without TLS_CERT or ACME_HOSTS", a.addr)
+			}
+		case strings.HasPrefix(entry, "http:"):
+			a = listenAddr{addr: strings.TrimPrefix(entry, "http:"), plain: true}
+		default:
+			a = listenAddr{addr: strings.TrimPrefix(entry, "https:")}
+		}
+		if a.addr == "" {
+			return nil, fmt.Errorf("invalid address %q", entry)
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// The port HTTPS is served on, for redirecting to: that of the first
+// address serving it, or 443
+func httpsPort(addrs []listenAddr) string {
+	for _, a := range addrs {
+		if a.redirect || a.plain || strings.HasPrefix(a.addr, "unix:") {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(strings.TrimPrefix(a.addr, "tcp:")); err == nil {
+			return port
+		}
+	}
+	return "443"
+}
+
+// A listener and how to serve it
+type listening struct {
+	l      net.Listener
+	srv    *http.Server
+	secure bool
+}
+
+// Serve every listener until ctx is done or one fails, then shut the
+// servers down, giving in-flight requests until timeout to finish
+func serveAll(ctx context.Context, ls []listening, certFile, keyFile string, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(ls))
+	var wg sync.WaitGroup
+	for _, l := range ls {
+		l := l
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if l.secure {
+				err = l.srv.ServeTLS(l.l, certFile, keyFile)
+			} else {
+				err = l.srv.Serve(l.l)
+			}
+			if err != http.ErrServerClosed {
+				errs <- fmt.Errorf("serving on %s: %s", l.l.Addr(), err)
+				cancel()
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	log.Println("shutting down")
+	sdNotify("STOPPING=1")
+
+	// Each server is shut down separately, at the same time
+	sctx, scancel := context.WithTimeout(context.Background(), timeout)
+	defer scancel()
+	servers := map[*http.Server]bool{}
+	for _, l := range ls {
+		servers[l.srv] = true
+	}
+	var sg sync.WaitGroup
+	for srv := range servers {
+		srv := srv
+		sg.Add(1)
+		go func() {
+			defer sg.Done()
+			if err := srv.Shutdown(sctx); err != nil {
+				log.Printf("error shutting down: %s", err)
+			}
+		}()
+	}
+	sg.Wait()
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Redirect every request to the same URL over HTTPS on port
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// Listen on addr: a TCP address, or unix:<path> for a Unix domain socket
+// (replacing one left behind by a previous run, but not one something's
+// still listening on)
+func listener(addr string) (net.Listener, error) {
+	path := strings.TrimPrefix(addr, "unix:")
+	if path == addr {
+		return net.Listen("tcp", strings.TrimPrefix(addr, "tcp:"))
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use, is fanatic already running?", path)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// The socket systemd passed when starting fanatic on a connection to it
+// (socket activation), nil if it didn't
+func activatedListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		log.Printf("systemd passed %d sockets, only using the first", n)
+	}
+
+	// Children shouldn't think the sockets are theirs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// Passed sockets start at file descriptor 3
+	l, err := net.FileListener(os.NewFile(3, "systemd socket"))
+	if err != nil {
+		return nil, fmt.Errorf("using the socket from systemd: %s", err)
+	}
+	log.Println("listening on the socket from systemd")
+	return l, nil
+}
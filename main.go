@@ -1,222 +1,168 @@
 package main
 
 import (
-	"bytes"
-	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"runtime/debug"
 	"strings"
-	"time"
-
-	"github.com/PuerkitoBio/goquery"
-	"github.com/jbub/podcasts"
-	"github.com/tidwall/gjson"
+	_ "time/tzdata"
 )
 
-const endpoint = "https://www.kcrw.com/music/shows/henry-rollins"
-
-const html = `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>fanatic!</title>
-    <style type="text/css">
-     body{font:0.8em sans-serif;margin:40px;}
-     h1{font-size:1.2em;}
-     h1 span{color:#ddd;}
-     h1:hover span {color:black;}
-     a:link,a:visited{border-bottom:1px solid #ccc;color:inherit;text-decoration:none;}
-     a:hover,a:active{background:#ff0;}
-     ul{margin:2em 0;padding:0;}
-     ul li{line-height:1.2rem;list-style-type:none;}
-     footer{bottom:40px;color:#ccc;position:absolute;}
-    </style>
-</head>
-<body>
-    <h1>fanatic!</h1>
-    <p>providing an <a href="/rss.xml">RSS feed</a> for Henry Rollins' <a href="https://www.kcrw.com/music/shows/henry-rollins">KCRW show</a> (because they don't)</p>
-    <footer>n.b. none of the shows are hosted here. be cool ~<a href="https://djl.io/">author</a></footer>
-</body>
-</html>
-`
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...
+// -X main.date=..."
+var version, commit, date string
 
-type Episode struct {
-	Title    string
-	Link     string
-	MP3      string
-	UUID     string
-	PubDate  time.Time
-	Duration time.Duration
+// The version of fanatic running: as set at build time, or the module
+// version for builds with go install, or "dev"
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
 }
 
-// Fetch given URL
-func get(url string) (string, error) {
-	log.Printf("fetching url %s", url)
-	res, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		err = fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
-		return "", err
+// The commit fanatic was built from and when, as set at build time or
+// recorded by the go command from version control. Either may be empty
+func buildCommit() (string, string) {
+	rev, built := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+			case s.Key == "vcs.time" && built == "":
+				built = s.Value
+			}
+		}
 	}
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return "", err
+	if len(rev) > 12 {
+		rev = rev[:12]
 	}
-
-	return string(body), nil
+	return rev, built
 }
 
-// Get the episodes from the endpoint
-// Errors will likely be either HTTP errors or HTML parsing errors
-// (e.g. the HTML changed and this needs to be rewritten accordingly)
-func fetchEpisodes(url string) ([]Episode, error) {
-	res, err := get(url)
-	if err != nil {
-		return nil, err
+// The build described in a line, e.g. "v1.2.0 (commit 3643da7, built
+// 2022-06-01T12:00:00Z)"
+func buildInfo() string {
+	rev, built := buildCommit()
+	var details []string
+	if rev != "" {
+		details = append(details, "commit "+rev)
 	}
-
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(res))
-	if err != nil {
-		return nil, err
+	if built != "" {
+		details = append(details, "built "+built)
 	}
-
-	var episodes []Episode
-
-	doc.Find("div.four-col.hub-row.no-border button.audio").Each(func(i int, s *goquery.Selection) {
-		jurl, exists := s.Attr("data-player-json")
-		if !exists {
-			return
-		}
-
-		res, err := get(jurl)
-		if err != nil {
-			return
-		}
-
-		json := string(res)
-		id := gjson.Get(json, "uuid").String()
-		link := gjson.Get(json, "url").String()
-		title := gjson.Get(json, "title").String()
-		mp3 := gjson.Get(json, "media.0.url").String()
-
-		durstr := gjson.Get(json, "duration").Int()
-		duration, err := time.ParseDuration(fmt.Sprintf("%ds", durstr))
-		if err != nil {
-			return
-		}
-
-		var pubdate time.Time
-		datestr := gjson.Get(json, "date").String()
-		parsed, err := time.Parse("2006-01-02T15:04:05Z", datestr)
-		if err != nil {
-			return
-		}
-		pubdate = parsed.AddDate(0, 0, -1)
-
-		episode := Episode{
-			Title:    title,
-			Link:     link,
-			MP3:      mp3,
-			UUID:     id,
-			PubDate:  pubdate,
-			Duration: duration,
-		}
-
-		episodes = append(episodes, episode)
-	})
-
-	if len(episodes) < 1 {
-		return nil, errors.New("no episodes found")
+	if len(details) == 0 {
+		return buildVersion()
 	}
-
-	return episodes, nil
+	return buildVersion() + " (" + strings.Join(details, ", ") + ")"
 }
 
-func generateXML() (string, error) {
-	episodes, err := fetchEpisodes(endpoint)
-	if err != nil {
-		return "", err
-	}
-
-	podcast := podcasts.Podcast{
-		Title:       "Henry Rollins - KCRW",
-		Description: "Henry Rollins hosts a mix of all kinds, from all over and all time.",
-		Language:    "EN",
-		Copyright:   "KCRW",
-		Link:        endpoint,
-	}
+// Flags shared by every command that scrapes KCRW
+var (
+	offline  bool
+	fixtures string
+	showSlug string
+)
 
-	for _, episode := range episodes {
-		podcast.AddItem(&podcasts.Item{
-			Title:    episode.Title,
-			GUID:     episode.UUID,
-			Duration: podcasts.NewDuration(episode.Duration),
-			Enclosure: &podcasts.Enclosure{
-				URL:  episode.MP3,
-				Type: "MP3",
-			},
-			PubDate: podcasts.NewPubDate(episode.PubDate),
-		})
-	}
+func scrapeFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&offline, "offline", false, "scrape responses saved by \"fanatic record\" instead of KCRW")
+	flags.StringVar(&fixtures, "fixtures", "testdata/kcrw", "where -offline reads saved responses from")
+	flags.StringVar(&showSlug, "show", "", "slug of the show to use (default: the default show)")
+}
 
-	feed, err := podcast.Feed()
-	if err != nil {
-		return "", nil
+// Whether args are for the -once flag from before there were commands,
+// which generate did, returning them without it. -o, which went with it,
+// is generate's too
+func legacyOnce(args []string) ([]string, bool) {
+	for i, arg := range args {
+		switch strings.TrimPrefix(arg, "-") {
+		case "-once", "-once=true", "once", "once=true":
+			return append(append([]string{}, args[:i]...), args[i+1:]...), true
+		}
 	}
-	var b bytes.Buffer
-	feed.Write(&b)
-	return b.String(), nil
+	return nil, false
+}
 
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: fanatic [command] [flags]
+
+commands:
+  serve     serve the feed over HTTP (the default)
+  generate  write the feed out once and exit
+  list      print the scraped episodes
+  export    write every episode out as CSV or JSON
+  import    merge an existing RSS feed into the saved episodes
+  validate  check the generated feed for problems
+  publish   write the site to a directory or an S3/GCS bucket
+  record    save KCRW's responses for replaying offline
+  lambda    handle requests as an AWS Lambda function (the default when
+            running in Lambda)
+  config    check the configuration ("fanatic config check") for mistakes
+
+Run "fanatic <command> -h" for a command's flags, or "fanatic -version"
+for the build.
+`)
 }
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	commands := map[string]func([]string) error{
+		"serve":    cmdServe,
+		"generate": cmdGenerate,
+		"list":     cmdList,
+		"export":   cmdExport,
+		"import":   cmdImport,
+		"validate": cmdValidate,
+		"publish":  cmdPublish,
+		"record":   cmdRecord,
+		"lambda":   cmdLambda,
+		"config":   cmdConfig,
 	}
 
-	log.Println("listening on", port)
-
-	xml, err := generateXML()
-	go func() {
-		ticker := time.NewTicker(time.Hour)
-		for {
-			<-ticker.C
-			xml, err = generateXML()
-			if err != nil {
-				log.Printf("error generating XML: %s", err)
-			}
-		}
-	}()
-
-	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if req.URL.Path != "/" {
-			w.WriteHeader(404)
-			w.Write([]byte("Not Found!"))
+	name, args := "serve", os.Args[1:]
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		name = "lambda"
+	}
+	if len(args) > 0 {
+		switch {
+		case args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+			usage()
 			return
-		}
-
-		w.Write([]byte(html))
-	})
-
-	http.HandleFunc("/rss.xml", func(w http.ResponseWriter, req *http.Request) {
-		if err != nil {
-			w.Write([]byte(fmt.Sprintf("error!\n%s", err)))
+		case args[0] == "-version" || args[0] == "--version":
+			fmt.Println("fanatic " + buildInfo())
 			return
+		case !strings.HasPrefix(args[0], "-"):
+			name, args = args[0], args[1:]
+		default:
+			if rest, ok := legacyOnce(args); ok {
+				log.Print(`"fanatic -once" is deprecated and will be removed, use "fanatic generate" instead`)
+				name, args = "generate", rest
+			}
 		}
-		w.Header().Set("Content-Type", "text/xml")
-		w.Write([]byte(xml))
-	})
+	}
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	pacer.SetDelay(envDuration("SCRAPE_DELAY", 0))
+	if err := setupProxy(); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	if reporter, err = reporterFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := cmd(args); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/server"
+)
+
+// The mirror serve keeps episodes in, if MIRROR_DIR or MIRROR_S3 is set
+var mirror *server.Mirror
+
+// Configure the mirror from MIRROR_DIR or MIRROR_S3, or nil if neither is
+// set
+func mirrorFromEnv() (*server.Mirror, error) {
+	var store server.MirrorStore
+	switch {
+	case getenv("MIRROR_S3") != "":
+		bs, err := bucketStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		store = bs
+	case getenv("MIRROR_DIR") != "":
+		store = server.DirStore(getenv("MIRROR_DIR"))
+	default:
+		return nil, nil
+	}
+
+	m := server.NewMirror(store)
+	m.Keep = envInt("MIRROR_KEEP", 0)
+	m.KeepBytes = int64(envFloat("MIRROR_KEEP_GB", 0) * 1e9)
+	m.VerifyInterval = envDuration("MIRROR_VERIFY_INTERVAL", 24*time.Hour)
+	return m, nil
+}
+
+// Keeps a mirror's files under media/ in the S3_* bucket, downloaded from
+// the bucket (or the CDN in front of it) rather than through fanatic
+type bucketStore struct {
+	b *bucket
+
+	// Public URL of the media/ folder
+	public string
+}
+
+// Configure a mirror store in the publish bucket. Listeners are sent to
+// MIRROR_PUBLIC_URL for the files, as the bucket itself may well not be
+// public
+func bucketStoreFromEnv() (*bucketStore, error) {
+	b, err := bucketFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	public := strings.TrimSuffix(getenv("MIRROR_PUBLIC_URL"), "/")
+	if public == "" {
+		return nil, errors.New("MIRROR_S3 needs MIRROR_PUBLIC_URL, where listeners can download the bucket's media/ folder from")
+	}
+	return &bucketStore{b: b, public: public}, nil
+}
+
+func (s *bucketStore) List() (map[string]int64, error) {
+	return s.b.list("media/")
+}
+
+func (s *bucketStore) Put(name string, r io.Reader, size int64) error {
+	return s.PutSum(name, r, size, "")
+}
+
+// PutSum streams the file to the bucket, with its SHA-256 in the object's
+// metadata
+func (s *bucketStore) PutSum(name string, r io.Reader, size int64, sum string) error {
+	contentType := "audio/mpeg"
+	if !strings.HasSuffix(name, ".mp3") {
+		contentType = "text/plain; charset=utf-8"
+	}
+	var meta map[string]string
+	if sum != "" {
+		meta = map[string]string{"sha256": sum}
+	}
+	return s.b.putStream("media/"+name, r, size, contentType, "public, max-age=31536000, immutable", meta)
+}
+
+func (s *bucketStore) Sum(name string) (string, error) {
+	meta, err := s.b.meta("media/" + name)
+	if err == errNoSuchKey {
+		return "", os.ErrNotExist
+	}
+	if err != nil {
+		return "", err
+	}
+	return meta["sha256"], nil
+}
+
+func (s *bucketStore) Open(name string) (io.ReadCloser, error) {
+	r, err := s.b.open("media/" + name)
+	if err == errNoSuchKey {
+		return nil, os.ErrNotExist
+	}
+	return r, err
+}
+
+func (s *bucketStore) Delete(name string) error {
+	err := s.b.delete("media/" + name)
+	if err == errNoSuchKey {
+		return nil
+	}
+	return err
+}
+
+func (s *bucketStore) URL(name string) string {
+	return s.public + "/" + name
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/djl/fanatic/notify"
+	"github.com/djl/fanatic/scraper"
+)
+
+// Where new episodes are announced, from the NOTIFY_*, PUSHOVER_* and
+// SMTP_* variables
+func episodeNotifier() notify.Notifier {
+	var n notify.Multi
+	if url := getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		n = append(n, notify.Webhook{URL: url})
+	}
+	if topic := getenv("NOTIFY_NTFY_TOPIC"); topic != "" {
+		n = append(n, notify.Ntfy{
+			Server: getenv("NOTIFY_NTFY_SERVER"),
+			Topic:  topic,
+			Token:  getenv("NOTIFY_NTFY_TOKEN"),
+		})
+	}
+	if token := getenv("PUSHOVER_TOKEN"); token != "" {
+		n = append(n, notify.Pushover{Token: token, User: getenv("PUSHOVER_USER")})
+	}
+	if addr := getenv("SMTP_ADDR"); addr != "" {
+		n = append(n, notify.SMTP{
+			Addr:     addr,
+			Username: getenv("SMTP_USERNAME"),
+			Password: getenv("SMTP_PASSWORD"),
+			From:     getenv("SMTP_FROM"),
+			To:       strings.Split(getenv("SMTP_TO"), ","),
+		})
+	}
+	if cmd := getenv("NOTIFY_COMMAND"); cmd != "" {
+		n = append(n, notify.Command(cmd))
+	}
+	return n
+}
+
+// Announce each new episode of the show
+func notifyNew(sh show, n notify.Notifier) func([]scraper.Episode) {
+	title := sh.builder().Title
+	return func(episodes []scraper.Episode) {
+		for _, e := range episodes {
+			log.Printf("new episode %s: %s", e.UUID, e.Title)
+			msg := notify.Message{
+				Title: fmt.Sprintf("New episode of %s: %s", title, e.Title),
+				Body:  episodeBody(e),
+				URL:   e.MP3,
+				Data:  e,
+			}
+			if err := n.Notify(msg); err != nil {
+				log.Printf("error sending new episode notification: %s", err)
+			}
+		}
+	}
+}
+
+// The title and MP3 of an episode, followed by what was played if known
+func episodeBody(e scraper.Episode) string {
+	body := fmt.Sprintf("%s\n%s", e.Title, e.MP3)
+	if len(e.Tracklist) > 0 {
+		body += "\n\nTracklist:\n"
+		for i, t := range e.Tracklist {
+			body += fmt.Sprintf("%d. %s\n", i+1, t)
+		}
+	}
+	return body
+}
@@ -0,0 +1,175 @@
+// Package notify sends short messages about the feed (new episodes,
+// scraping trouble) to webhooks and other services.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Message is a notification
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+
+	// URL is a link for the message, if it has one
+	URL string `json:"url,omitempty"`
+
+	// Data is the thing the message is about (an episode, scrape health)
+	// for receivers that want more than text
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Notifier delivers messages
+type Notifier interface {
+	Notify(Message) error
+}
+
+// Multi sends every message to each of its notifiers, carrying on past
+// failures
+type Multi []Notifier
+
+func (m Multi) Notify(msg Message) error {
+	var errs []string
+	for _, n := range m {
+		if err := n.Notify(msg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notify: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Send req, treating anything but a 2xx as an error
+func do(req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, res.Status)
+	}
+	return nil
+}
+
+// Webhook POSTs messages as JSON to URL
+type Webhook struct {
+	URL string
+}
+
+func (w Webhook) Notify(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(req)
+}
+
+// Command runs a shell command for each message, with the message as JSON
+// on stdin and its title, body and URL in $FANATIC_TITLE, $FANATIC_BODY
+// and $FANATIC_URL, e.g. to send it with mail(1). It's killed if it takes
+// longer than CommandTimeout
+type Command string
+
+// CommandTimeout is how long a Command may run
+const CommandTimeout = time.Minute
+
+func (c Command) Notify(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", string(c))
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"FANATIC_TITLE="+msg.Title,
+		"FANATIC_BODY="+msg.Body,
+		"FANATIC_URL="+msg.URL,
+	)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", CommandTimeout)
+		}
+		return fmt.Errorf("%q: %s", string(c), err)
+	}
+	return nil
+}
+
+// Ntfy publishes messages to a topic on an ntfy server (https://ntfy.sh
+// if Server is empty), with a bearer Token if the topic needs one
+type Ntfy struct {
+	Server string
+	Topic  string
+	Token  string
+}
+
+func (n Ntfy) Notify(msg Message) error {
+	server := n.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(server, "/")+"/"+n.Topic, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Title)
+	if msg.URL != "" {
+		req.Header.Set("Click", msg.URL)
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return do(req)
+}
+
+// Pushover sends messages through Pushover with an application Token to a
+// User (or group) key
+type Pushover struct {
+	Token string
+	User  string
+}
+
+func (p Pushover) Notify(msg Message) error {
+	form := url.Values{
+		"token":   {p.Token},
+		"user":    {p.User},
+		"title":   {msg.Title},
+		"message": {msg.Body},
+	}
+	if msg.URL != "" {
+		form.Set("url", msg.URL)
+	}
+
+	req, err := http.NewRequest("POST", "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(req)
+}
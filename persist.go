@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+	"github.com/djl/fanatic/server"
+)
+
+// A show's feed as kept between runs, in the bucket by lambda and in
+// STATE_DIR by serve
+type savedFeed struct {
+	Updated  time.Time         `json:"updated"`
+	XML      string            `json:"xml"`
+	Episodes []scraper.Episode `json:"episodes"`
+}
+
+// Take STATE_DIR for the running command (serve or import), so another
+// can't overwrite what it saves there. The lock names the command and its
+// process, and one left by a process that's gone is taken over. The
+// returned function releases it
+func lockStateDir(dir, command string) (func(), error) {
+	path := filepath.Join(dir, "lock")
+	owner := fmt.Sprintf("%s %d", command, os.Getpid())
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(owner + "\n")
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		holder := strings.TrimSpace(string(data))
+		if holder != "" && lockHolderRunning(holder) {
+			return nil, fmt.Errorf("%s is in use by fanatic %s (%s)", dir, holder, path)
+		}
+		log.Printf("taking over %s, left by fanatic %s", path, holder)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// Whether the process named by a lock ("serve 1234") is still running
+func lockHolderRunning(holder string) bool {
+	fields := strings.Fields(holder)
+	pid, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+func savedFeedPath(dir, slug string) string {
+	return filepath.Join(dir, slug+".json")
+}
+
+// Read the feed saved in dir for the show with the given slug. The error
+// satisfies os.IsNotExist if there isn't one
+func readSavedFeed(dir, slug string) (savedFeed, error) {
+	var f savedFeed
+	data, err := ioutil.ReadFile(savedFeedPath(dir, slug))
+	if err != nil {
+		return f, err
+	}
+	if err = json.Unmarshal(data, &f); err != nil {
+		return f, err
+	}
+
+	// Feeds saved before episodes had GUIDs of their own used their UUIDs,
+	// and before they had numbers they were only in their titles
+	for i, e := range f.Episodes {
+		if e.GUID == "" {
+			f.Episodes[i].GUID = e.UUID
+		}
+		if e.Number == 0 {
+			f.Episodes[i].Number = scraper.EpisodeNumber(e.Title)
+		}
+	}
+	return f, nil
+}
+
+// Load the show's feed saved in dir, reporting whether there was one
+func loadFeed(dir string, sh server.Show) bool {
+	f, err := readSavedFeed(dir, sh.Slug)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Printf("error loading saved feed: %s", err)
+		return false
+	}
+
+	sh.State.Set(f.XML, f.Episodes, f.Updated)
+	return true
+}
+
+// Write the feed for the show with the given slug to dir
+func writeSavedFeed(dir, slug string, f savedFeed) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFeed(string(data), savedFeedPath(dir, slug))
+}
+
+// Save the show's feed in dir
+func saveFeed(dir string, sh server.Show) {
+	xml, episodes, _ := sh.State.Get()
+	if err := writeSavedFeed(dir, sh.Slug, savedFeed{sh.State.LastUpdated(), xml, episodes}); err != nil {
+		log.Printf("error saving feed: %s", err)
+	}
+}
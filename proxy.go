@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The config file's proxy, and the hosts it's bypassed for. A zero one
+// leaves requests to HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+type proxySetting struct {
+	url     *url.URL
+	noProxy []string
+}
+
+// The proxySetting in force, which a reload can change without touching
+// http.DefaultTransport while it's in use
+var (
+	currentProxy atomic.Value
+	proxyOnce    sync.Once
+)
+
+// Send outbound requests through the config file's proxy, if it names
+// one. Otherwise HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honoured, as
+// http.DefaultTransport always does. Called again on reload to change it
+func setupProxy() error {
+	proxy := currentConf().Proxy
+	var setting proxySetting
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy %q", proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid proxy %q: scheme must be http, https or socks5", proxy)
+		}
+		setting = proxySetting{u, strings.Split(getenv("NO_PROXY")+","+os.Getenv("no_proxy"), ",")}
+	}
+
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		if setting.url == nil {
+			return nil
+		}
+		return fmt.Errorf("can't set a proxy on %T", http.DefaultTransport)
+	}
+	currentProxy.Store(setting)
+	proxyOnce.Do(func() {
+		fromEnv := t.Proxy
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			setting := currentProxy.Load().(proxySetting)
+			switch {
+			case setting.url == nil && fromEnv != nil:
+				return fromEnv(req)
+			case setting.url == nil || bypassProxy(req.URL.Hostname(), setting.noProxy):
+				return nil, nil
+			}
+			return setting.url, nil
+		}
+	})
+	return nil
+}
+
+// Whether requests to host go direct: it's loopback (like the Lambda
+// runtime API) or matches a NO_PROXY entry, a host name or domain suffix,
+// an IP address or CIDR block, or "*"
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		switch {
+		case entry == "":
+		case entry == "*":
+			return true
+		case ip != nil:
+			if _, block, err := net.ParseCIDR(entry); err == nil && block.Contains(ip) {
+				return true
+			}
+			if ip.Equal(net.ParseIP(entry)) {
+				return true
+			}
+		default:
+			entry = strings.TrimPrefix(entry, "*")
+			if host == strings.TrimPrefix(entry, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+				return true
+			}
+		}
+	}
+	return false
+}
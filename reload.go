@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/djl/fanatic/server"
+)
+
+// The shows serve runs, rebuilt from the config file on SIGHUP or from the
+// admin page without closing the listeners or losing the feeds
+type site struct {
+	ctx      context.Context
+	sched    server.Schedule
+	checker  server.LinkChecker
+	stateDir string
+
+	mu    sync.Mutex
+	shows []server.Show
+	stop  context.CancelFunc
+	logs  *server.LogBuffer
+
+	handler atomic.Value
+
+	// Closed once the default show has a feed to serve
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// Refreshes are taken to be stuck (e.g. on a request that never ends)
+// once they're this far behind schedule
+const refreshStuckAfter = 15 * time.Minute
+
+func (s *site) markReady() {
+	s.readyOnce.Do(func() { close(s.ready) })
+}
+
+// Whether every show's refresh loop is keeping to its schedule, reporting
+// the first that isn't
+func (s *site) refreshing() (string, bool) {
+	s.mu.Lock()
+	shows := s.shows
+	s.mu.Unlock()
+	for _, sh := range shows {
+		next := sh.State.NextRefresh()
+		if !next.IsZero() && time.Since(next) > refreshStuckAfter {
+			return sh.Slug, false
+		}
+	}
+	return "", true
+}
+
+func (s *site) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.handler.Load().(http.Handler).ServeHTTP(w, req)
+}
+
+// The handler's options, as configured now
+func (s *site) options() (server.Options, error) {
+	opts := optionsFromEnv()
+	if opts.Pprof && !opts.Auth.Enabled() {
+		log.Println("pprof needs auth set, not serving /debug/pprof/")
+	}
+	if opts.Auth.Enabled() && s.logs == nil {
+		// Keep the recent log for the admin page
+		s.logs = server.NewLogBuffer(200)
+		log.SetOutput(io.MultiWriter(os.Stderr, s.logs))
+	}
+	opts.Logs = s.logs
+
+	var err error
+	if opts.LandingTemplate, err = landingTemplate(); err != nil {
+		return opts, err
+	}
+	opts.MediaTransport = kcrwTransport(false)
+	if mirror != nil {
+		mirror.Transport = kcrwTransport(true)
+		opts.Mirror = mirror
+		opts.MediaRedirect = getenv("MEDIA_PROXY") == ""
+	}
+	opts.Transcripts = transcripts
+	opts.Reload = s.reload
+	return opts, nil
+}
+
+// Build and start refreshing the configured shows, stopping any running
+// before. Shows that were already running keep their feeds until they're
+// refreshed with the new settings, straight away. Called with s.mu held
+func (s *site) start(configured []show) error {
+	opts, err := s.options()
+	if err != nil {
+		return err
+	}
+	shows := newShows(configured)
+
+	// Start from the feeds served until now or, at first, those saved by
+	// the last run, so there's something to serve before the first scrape
+	// finishes
+	previous := map[string]server.Show{}
+	for _, sh := range s.shows {
+		previous[sh.Slug] = sh
+	}
+	loaded := true
+	for _, sh := range shows {
+		if prev, ok := previous[sh.Slug]; ok {
+			xml, episodes, _ := prev.State.Get()
+			sh.State.Set(xml, episodes, prev.State.LastUpdated())
+			sh.State.SetEnabled(prev.State.Enabled())
+		} else if s.stateDir == "" || !loadFeed(s.stateDir, sh) {
+			loaded = false
+		}
+		sh, isDefault := sh, sh.Slug == shows[0].Slug
+		sh.State.OnRefresh = func() {
+			if s.stateDir != "" {
+				saveFeed(s.stateDir, sh)
+			}
+			if isDefault {
+				s.markReady()
+			}
+		}
+	}
+	if xml, _, _ := shows[0].State.Get(); xml != "" {
+		s.markReady()
+	}
+
+	// Download new episodes whenever a show changes
+	var mirrored []*server.State
+	if opts.Mirror != nil {
+		for i, sh := range shows {
+			if len(configured[i].sources) > 0 {
+				continue
+			}
+			state, onChange := sh.State, sh.State.OnChange
+			state.OnChange = func() {
+				onChange()
+				opts.Mirror.Kick()
+			}
+			mirrored = append(mirrored, state)
+		}
+	}
+
+	// Look for transcripts of new episodes too
+	var transcribed []*server.State
+	if transcripts != nil {
+		for i, sh := range shows {
+			if len(configured[i].sources) > 0 {
+				continue
+			}
+			state, onChange := sh.State, sh.State.OnChange
+			state.OnChange = func() {
+				onChange()
+				transcripts.Kick()
+			}
+			transcribed = append(transcribed, state)
+		}
+	}
+
+	if s.stop != nil {
+		s.stop()
+	}
+	ctx, stop := context.WithCancel(s.ctx)
+	s.shows, s.stop = shows, stop
+	s.handler.Store(server.NewHandler(shows, opts))
+
+	refresh := func() {
+		for _, sh := range refreshOrder(configured, shows) {
+			sh.State.Refresh()
+		}
+	}
+	if loaded || len(previous) > 0 {
+		go refresh()
+	} else {
+		refresh()
+	}
+
+	if opts.Mirror != nil {
+		opts.Mirror.Kick()
+		go opts.Mirror.Run(ctx, mirrored)
+	}
+	if transcripts != nil {
+		transcripts.Kick()
+		go transcripts.Run(ctx, transcribed)
+	}
+	for i, sh := range shows {
+		sched := s.sched
+		if configured[i].interval > 0 {
+			sched = server.IntervalSchedule{Interval: configured[i].interval, Jitter: envDuration("REFRESH_JITTER", 0)}
+		}
+		go server.RefreshLoop(ctx, sched, sh.State, opts.Cache)
+
+		// Combined shows' episodes are checked by their sources
+		if s.checker.Interval > 0 && len(configured[i].sources) == 0 {
+			go s.checker.Run(ctx, sh.State)
+		}
+	}
+	return nil
+}
+
+// Re-read the config file and restart the shows with it, along with the
+// settings read once at startup: the refresh schedule, link checks,
+// SCRAPE_DELAY, the proxy and error reporting. A config with mistakes is
+// refused, leaving everything as it was
+func (s *site) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := currentConf()
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	// Settings of the wrong kind would be fatal wherever they're read, so
+	// they're checked before anything can read them
+	if errs := checkSettingsWith(c.getenv); len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	setConf(c)
+
+	oldSched, oldChecker, oldReporter := s.sched, s.checker, reporter
+	configured, err := s.apply()
+	if err != nil {
+		setConf(old)
+		s.sched, s.checker, reporter = oldSched, oldChecker, oldReporter
+		if err := setupProxy(); err != nil {
+			log.Printf("error restoring proxy: %s", err)
+		}
+		return err
+	}
+	pacer.SetDelay(envDuration("SCRAPE_DELAY", 0))
+	log.Printf("reloaded config, serving %d shows", len(configured))
+	return nil
+}
+
+// Start the shows as the config now says, with the settings it changes.
+// Called with s.mu held
+func (s *site) apply() ([]show, error) {
+	if errs := checkConfig(); len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+	sched, err := scheduleFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if reporter, err = reporterFromEnv(); err != nil {
+		return nil, err
+	}
+	if err := setupProxy(); err != nil {
+		return nil, err
+	}
+	configured, err := configuredShows()
+	if err != nil {
+		return nil, err
+	}
+	s.sched, s.checker = sched, linkCheckerFromEnv()
+	return configured, s.start(configured)
+}
+
+// Reload on SIGHUP until ctx is done, telling systemd while it happens
+func (s *site) reloadOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		log.Println("reloading config on SIGHUP")
+		sdNotify("RELOADING=1")
+		if err := s.reload(); err != nil {
+			log.Printf("error reloading config: %s", err)
+		}
+		sdNotify("READY=1")
+	}
+}
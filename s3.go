@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A bucket in S3 or anything speaking its API (GCS interoperability mode,
+// MinIO, R2...), accessed with requests signed using AWS Signature V4
+type bucket struct {
+	endpoint  *url.URL
+	name      string
+	region    string
+	prefix    string
+	pathStyle bool
+	acl       string
+	accessKey string
+	secretKey string
+}
+
+// Configure a bucket from S3_* and the standard AWS credential variables
+func bucketFromEnv() (*bucket, error) {
+	b := &bucket{
+		name:      getenv("S3_BUCKET"),
+		region:    getenv("S3_REGION"),
+		prefix:    strings.Trim(getenv("S3_PREFIX"), "/"),
+		pathStyle: getenv("S3_PATH_STYLE") != "",
+		acl:       getenv("S3_ACL"),
+		accessKey: getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if b.name == "" {
+		return nil, errors.New("S3_BUCKET is not set")
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+
+	endpoint := getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + b.region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT: %s", err)
+	}
+	b.endpoint = u
+	return b, nil
+}
+
+// The URL of an object, either bucket.host/key or host/bucket/key
+func (b *bucket) objectURL(key string) *url.URL {
+	if b.prefix != "" {
+		key = b.prefix + "/" + key
+	}
+	u := *b.endpoint
+	if b.pathStyle {
+		u.Path = "/" + b.name + "/" + key
+	} else {
+		u.Host = b.name + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = awsEscapePath(u.Path)
+	return &u
+}
+
+// Escape a path the way SigV4 expects: everything but unreserved
+// characters and slashes is percent-encoded
+func awsEscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// The headers of an upload
+func (b *bucket) putHeader(contentType, cacheControl string) http.Header {
+	h := http.Header{}
+	h.Set("Content-Type", contentType)
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+	if b.acl != "" {
+		h.Set("X-Amz-Acl", b.acl)
+	}
+	return h
+}
+
+// Upload an object
+func (b *bucket) put(key string, body []byte, contentType, cacheControl string) error {
+	_, err := b.do("PUT", key, body, b.putHeader(contentType, cacheControl))
+	return err
+}
+
+// Upload an object of size bytes read from r as it's sent, with its
+// payload unsigned so it needn't be read (or held in memory) beforehand.
+// meta is saved with it as x-amz-meta-* headers
+func (b *bucket) putStream(key string, r io.Reader, size int64, contentType, cacheControl string, meta map[string]string) error {
+	h := b.putHeader(contentType, cacheControl)
+	for k, v := range meta {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+	res, err := b.send("PUT", key, r, size, unsignedPayload, h)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Fetch an object
+func (b *bucket) get(key string) ([]byte, error) {
+	return b.do("GET", key, nil, http.Header{})
+}
+
+// Fetch an object, to be read as it arrives
+func (b *bucket) open(key string) (io.ReadCloser, error) {
+	res, err := b.send("GET", key, nil, 0, sha256Hex(nil), http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// The metadata saved with an object, by lower case name without the
+// x-amz-meta- prefix
+func (b *bucket) meta(key string) (map[string]string, error) {
+	res, err := b.send("HEAD", key, nil, 0, sha256Hex(nil), http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	meta := map[string]string{}
+	for k := range res.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-meta-") {
+			meta[strings.TrimPrefix(k, "x-amz-meta-")] = res.Header.Get(k)
+		}
+	}
+	return meta, nil
+}
+
+// Remove an object
+func (b *bucket) delete(key string) error {
+	_, err := b.do("DELETE", key, nil, http.Header{})
+	return err
+}
+
+// The keys and sizes of the objects under prefix
+func (b *bucket) list(prefix string) (map[string]int64, error) {
+	full := prefix
+	if b.prefix != "" {
+		full = b.prefix + "/" + prefix
+	}
+
+	objects := map[string]int64{}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {full}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		u := *b.endpoint
+		if b.pathStyle {
+			u.Path = "/" + b.name
+		} else {
+			u.Host = b.name + "." + u.Host
+			u.Path = "/"
+		}
+		u.RawQuery = strings.Replace(q.Encode(), "+", "%20", -1)
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		b.sign(req, sha256Hex(nil), time.Now().UTC())
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("GET %s: %s\n%s", u.String(), res.Status, data)
+		}
+
+		var page struct {
+			Contents []struct {
+				Key  string
+				Size int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			objects[strings.TrimPrefix(c.Key, full)] = c.Size
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// The error returned for requests about objects that don't exist
+var errNoSuchKey = errors.New("no such key")
+
+func (b *bucket) do(method, key string, body []byte, h http.Header) ([]byte, error) {
+	res, err := b.send(method, key, bytes.NewReader(body), int64(len(body)), sha256Hex(body), h)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// What's signed instead of the payload's hash for payloads that aren't
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Make a signed request about an object with the size byte body, whose
+// hex SHA-256 is payloadHash (or unsignedPayload), returning the response
+// if it's a success
+func (b *bucket) send(method, key string, body io.Reader, size int64, payloadHash string, h http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header = h
+	b.sign(req, payloadHash, time.Now().UTC())
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errNoSuchKey
+	}
+	if res.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s\n%s", method, key, res.Status, data)
+	}
+	return res, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Add an AWS Signature Version 4 Authorization header to req, whose body
+// has the hex SHA-256 payloadHash (or is unsignedPayload)
+func (b *bucket) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + b.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), day)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+	req.Header.Del("Host")
+	req.Host = req.URL.Host
+}
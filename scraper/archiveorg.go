@@ -0,0 +1,131 @@
+package scraper
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ArchiveOrgURL is where the Internet Archive's APIs are
+var ArchiveOrgURL = "https://archive.org"
+
+// How many search results to ask archive.org for at a time
+const archiveOrgRows = 100
+
+// ArchiveOrg finds old episodes uploaded to the Internet Archive, for
+// filling in those KCRW no longer lists. query is an archive.org advanced
+// search, e.g. `creator:"Henry Rollins" AND subject:KCRW`, and each item
+// it finds with an MP3 is an episode. Items' dates are taken to be in the
+// Scraper's Location
+func (s *Scraper) ArchiveOrg(query string) ([]Episode, error) {
+	return s.ArchiveOrgContext(context.Background(), query)
+}
+
+// ArchiveOrgContext is ArchiveOrg with a context for its requests
+func (s *Scraper) ArchiveOrgContext(ctx context.Context, query string) ([]Episode, error) {
+	var episodes []Episode
+	for page := 1; ; page++ {
+		v := url.Values{}
+		v.Set("q", query)
+		for _, f := range []string{"identifier", "title", "date", "description"} {
+			v.Add("fl[]", f)
+		}
+		v.Set("rows", strconv.Itoa(archiveOrgRows))
+		v.Set("page", strconv.Itoa(page))
+		v.Set("output", "json")
+
+		body, err := s.get(ctx, ArchiveOrgURL+"/advancedsearch.php?"+v.Encode())
+		if err != nil {
+			return nil, err
+		}
+		docs := gjson.Get(body, "response.docs").Array()
+		for _, doc := range docs {
+			e, ok, err := s.archiveOrgItem(ctx, doc)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				episodes = append(episodes, e)
+			}
+		}
+
+		if len(docs) < archiveOrgRows || int64(page*archiveOrgRows) >= gjson.Get(body, "response.numFound").Int() {
+			return episodes, nil
+		}
+	}
+}
+
+// Make an episode of an archive.org search result, reporting false if it
+// has no MP3 or date
+func (s *Scraper) archiveOrgItem(ctx context.Context, doc gjson.Result) (Episode, bool, error) {
+	loc := s.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	id := doc.Get("identifier").String()
+	date, err := time.ParseInLocation("2006-01-02", prefix(doc.Get("date").String(), 10), loc)
+	if id == "" || err != nil {
+		return Episode{}, false, nil
+	}
+
+	meta, err := s.get(ctx, ArchiveOrgURL+"/metadata/"+url.PathEscape(id))
+	if err != nil {
+		return Episode{}, false, err
+	}
+
+	// The first MP3, which is the original upload if there's more than one
+	// encoding
+	var mp3 gjson.Result
+	for _, f := range gjson.Get(meta, "files").Array() {
+		if strings.HasSuffix(strings.ToLower(f.Get("name").String()), ".mp3") {
+			mp3 = f
+			break
+		}
+	}
+	if !mp3.Exists() {
+		return Episode{}, false, nil
+	}
+
+	title := cleanText(doc.Get("title").String())
+	e := Episode{
+		Title:       title,
+		Number:      EpisodeNumber(title),
+		Link:        ArchiveOrgURL + "/details/" + url.PathEscape(id),
+		MP3:         ArchiveOrgURL + "/download/" + url.PathEscape(id) + "/" + url.PathEscape(mp3.Get("name").String()),
+		UUID:        "archive-org-" + id,
+		PubDate:     date,
+		Duration:    archiveOrgLength(mp3.Get("length").String()),
+		Description: doc.Get("description").String(),
+		MediaType:   DefaultMediaType,
+		Length:      mp3.Get("size").Int(),
+	}
+	return e, true, nil
+}
+
+// Parse the length archive.org gives a file, either seconds or [H:]MM:SS
+func archiveOrgLength(s string) time.Duration {
+	if !strings.Contains(s, ":") {
+		secs, _ := strconv.ParseFloat(s, 64)
+		return time.Duration(secs) * time.Second
+	}
+	var secs int
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func prefix(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
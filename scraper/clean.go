@@ -0,0 +1,82 @@
+package scraper
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Tidy text from KCRW for display: entities unescaped (even when escaped
+// twice over), UTF-8 that was mistaken for Latin-1 repaired, control
+// characters dropped, runs of whitespace collapsed and the result put in
+// Unicode normal form C, so apps don't show "&amp;" or "Ã©"
+func cleanText(s string) string {
+	for i := 0; i < 3 && strings.Contains(s, "&"); i++ {
+		u := html.UnescapeString(s)
+		if u == s {
+			break
+		}
+		s = u
+	}
+	s = fixMojibake(s)
+
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return norm.NFC.String(b.String())
+}
+
+// Undo UTF-8 having been decoded as Latin-1 (or Windows-1252) somewhere
+// along the way, e.g. "BjÃ¶rk" for "Björk". Only text that's entirely
+// such characters, and turns into valid UTF-8 with something other than
+// ASCII in it, is changed
+func fixMojibake(s string) string {
+	var b []byte
+	multibyte := false
+	for _, r := range s {
+		c, ok := latin1Byte(r)
+		if !ok {
+			return s
+		}
+		if c >= 0x80 {
+			multibyte = true
+		}
+		b = append(b, c)
+	}
+	if !multibyte || !utf8.Valid(b) {
+		return s
+	}
+	return string(b)
+}
+
+// The byte r would have been in Windows-1252, which most mojibake is
+var cp1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+func latin1Byte(r rune) (byte, bool) {
+	if r < 0x100 {
+		return byte(r), true
+	}
+	c, ok := cp1252[r]
+	return c, ok
+}
@@ -0,0 +1,220 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// DefaultMediaType is assumed for episodes whose media type isn't known
+const DefaultMediaType = "audio/mpeg"
+
+// MIME types of the formats KCRW's player JSON lists
+var formatTypes = map[string]string{
+	"mp3": "audio/mpeg",
+	"aac": "audio/aac",
+	"m4a": "audio/mp4",
+	"mp4": "audio/mp4",
+	"ogg": "audio/ogg",
+}
+
+// MediaPreference ranks an episode's media to choose its enclosure
+type MediaPreference struct {
+	// Formats, e.g. ["mp3", "aac"], in order of preference. Unlisted
+	// formats come after them. Just mp3 if empty
+	Formats []string `json:"formats"`
+
+	// Bitrate is "highest" (the default) or "lowest", to choose between
+	// media of the same format
+	Bitrate string `json:"bitrate"`
+}
+
+// Choose the best of the media array, returning its URL and MIME type (if
+// its format is known). Entries without a format are guessed from the
+// URL's extension, and those without a bitrate are chosen last
+func (p MediaPreference) pick(media gjson.Result) (string, string) {
+	formats := p.Formats
+	if len(formats) == 0 {
+		formats = []string{"mp3"}
+	}
+	rank := func(format string) int {
+		for i, f := range formats {
+			if strings.EqualFold(f, format) {
+				return i
+			}
+		}
+		return len(formats)
+	}
+
+	var best, bestFormat string
+	bestRank, bestBitrate := 0, int64(0)
+	for _, m := range media.Array() {
+		url := m.Get("url").String()
+		if url == "" {
+			continue
+		}
+		format := strings.ToLower(m.Get("format").String())
+		if format == "" {
+			format = strings.ToLower(strings.TrimPrefix(path.Ext(strings.SplitN(url, "?", 2)[0]), "."))
+		}
+		bitrate := m.Get("bitrate").Int()
+
+		r := rank(format)
+		var better bool
+		switch {
+		case best == "" || r < bestRank:
+			better = true
+		case r > bestRank || bitrate == 0:
+		case bestBitrate == 0:
+			better = true
+		case p.Bitrate == "lowest":
+			better = bitrate < bestBitrate
+		default:
+			better = bitrate > bestBitrate
+		}
+		if better {
+			best, bestFormat, bestRank, bestBitrate = url, format, r, bitrate
+		}
+	}
+	return best, formatTypes[bestFormat]
+}
+
+// Media is what a HEAD request says about an episode's audio, and its
+// Duration if that had to be worked out from the MP3
+type Media struct {
+	Type     string
+	Length   int64
+	Duration time.Duration
+
+	// Final is where the URL redirected to, if anywhere, as of Checked
+	Final   string
+	Checked time.Time
+}
+
+// MediaCache remembers what's been learnt about each MP3, so every refresh
+// doesn't HEAD every episode again
+type MediaCache struct {
+	mu    sync.Mutex
+	media map[string]Media
+}
+
+// NewMediaCache returns an empty MediaCache
+func NewMediaCache() *MediaCache {
+	return &MediaCache{media: map[string]Media{}}
+}
+
+func (c *MediaCache) get(url string) (Media, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.media[url]
+	return m, ok
+}
+
+func (c *MediaCache) set(url string, m Media) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.media[url] = m
+}
+
+// HEAD the given URL for its Content-Type and Content-Length
+func (s *Scraper) head(ctx context.Context, url string) (Media, error) {
+	client := s.client()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return Media{}, err
+	}
+	log.Printf("probing url %s", url)
+	res, err := client.Do(req)
+	if err != nil {
+		return Media{}, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return Media{}, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	m := Media{Type: res.Header.Get("Content-Type"), Length: res.ContentLength, Checked: time.Now()}
+	if final := res.Request.URL.String(); final != url {
+		m.Final = final
+	}
+	if i := strings.Index(m.Type, ";"); i >= 0 {
+		m.Type = strings.TrimSpace(m.Type[:i])
+	}
+	if !strings.HasPrefix(m.Type, "audio/") {
+		m.Type = ""
+	}
+	if m.Length < 0 {
+		m.Length = 0
+	}
+	return m, nil
+}
+
+// A copy of the episodes as scraped with what's known about their MP3s
+// filled in, probing those that aren't known yet (or failed before) or are
+// due to be resolved again. The episodes themselves are left as scraped,
+// so an unchanged page's are probed again every time
+func (s *Scraper) probed(ctx context.Context, episodes []Episode) []Episode {
+	if s.Media == nil {
+		return episodes
+	}
+	probed := append([]Episode(nil), episodes...)
+	s.probe(ctx, probed)
+	return probed
+}
+
+// Fill in the type and length of each episode's MP3, from the cache or a
+// HEAD request, and the duration of any without one from the start of the
+// MP3. Failures are logged and retried on the next scrape, and when
+// re-resolving redirects fails the last answer is kept
+func (s *Scraper) probe(ctx context.Context, episodes []Episode) {
+	for i, e := range episodes {
+		m, ok := s.Media.get(e.MP3)
+		if ok && s.ResolveRedirects > 0 && time.Since(m.Checked) > s.ResolveRedirects {
+			// The redirect may lead somewhere else by now
+			ok = false
+		}
+		if !ok {
+			fresh, err := s.head(ctx, e.MP3)
+			if err != nil {
+				log.Printf("error probing %s: %s", e.MP3, err)
+				if m.Checked.IsZero() {
+					continue
+				}
+			} else {
+				fresh.Duration = m.Duration
+				m = fresh
+				s.Media.set(e.MP3, m)
+			}
+		}
+
+		if e.Duration == 0 && m.Duration == 0 {
+			d, err := s.mp3Duration(ctx, e.MP3, m.Length)
+			if err != nil {
+				log.Printf("error reading duration of %s: %s", e.MP3, err)
+			} else {
+				m.Duration = d
+				s.Media.set(e.MP3, m)
+			}
+		}
+
+		if m.Type != "" {
+			episodes[i].MediaType = m.Type
+		}
+		episodes[i].Length = m.Length
+		if e.Duration == 0 {
+			episodes[i].Duration = m.Duration
+		}
+		if s.ResolveRedirects > 0 && m.Final != "" {
+			episodes[i].MP3 = m.Final
+		}
+	}
+}
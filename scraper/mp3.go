@@ -0,0 +1,199 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How much of an MP3 to fetch when looking for its first frame
+const mp3ChunkSize = 64 * 1024
+
+var errNoFrame = errors.New("no MP3 frame found")
+
+// Bitrates (kbps) of Layer III frames by bitrate index, for MPEG-1 and
+// MPEG-2/2.5
+var (
+	mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+)
+
+// Sample rates by version bits (MPEG-2.5, reserved, MPEG-2, MPEG-1) and
+// sample rate index
+var sampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// An MPEG audio frame header
+type mp3Frame struct {
+	mpeg1      bool
+	bitrate    int // bits per second
+	sampleRate int
+	mono       bool
+}
+
+// Parse the frame header at the start of b
+func parseFrame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := (b[1] >> 3) & 3
+	layer := (b[1] >> 1) & 3
+	rateIndex := b[2] >> 4
+	srIndex := (b[2] >> 2) & 3
+	if version == 1 || layer != 1 || rateIndex == 0 || rateIndex == 15 || srIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	f := mp3Frame{
+		mpeg1:      version == 3,
+		sampleRate: sampleRates[version][srIndex],
+		mono:       b[3]>>6 == 3,
+	}
+	if f.mpeg1 {
+		f.bitrate = mpeg1Bitrates[rateIndex] * 1000
+	} else {
+		f.bitrate = mpeg2Bitrates[rateIndex] * 1000
+	}
+	return f, true
+}
+
+func (f mp3Frame) samplesPerFrame() int {
+	if f.mpeg1 {
+		return 1152
+	}
+	return 576
+}
+
+// The number of frames a VBR header at the start of b says the file has
+func vbrFrames(b []byte, f mp3Frame) (uint32, bool) {
+	sideInfo := 17
+	switch {
+	case f.mpeg1 && !f.mono:
+		sideInfo = 32
+	case !f.mpeg1 && f.mono:
+		sideInfo = 9
+	}
+
+	if x := b[4+sideInfo:]; len(x) >= 12 && (bytes.HasPrefix(x, []byte("Xing")) || bytes.HasPrefix(x, []byte("Info"))) {
+		if binary.BigEndian.Uint32(x[4:])&1 != 0 {
+			return binary.BigEndian.Uint32(x[8:]), true
+		}
+	}
+	if v := b[4+32:]; len(v) >= 18 && bytes.HasPrefix(v, []byte("VBRI")) {
+		return binary.BigEndian.Uint32(v[14:]), true
+	}
+	return 0, false
+}
+
+// The size of the ID3v2 tag at the start of b, if there is one
+func id3Size(b []byte) int {
+	if len(b) < 10 || !bytes.HasPrefix(b, []byte("ID3")) {
+		return 0
+	}
+	size := int(b[6]&0x7f)<<21 | int(b[7]&0x7f)<<14 | int(b[8]&0x7f)<<7 | int(b[9]&0x7f)
+	if b[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return 10 + size
+}
+
+// GET part of url, returning it along with the full length of the file if
+// the server says (-1 otherwise)
+func (s *Scraper) getRange(ctx context.Context, url string, offset int64) ([]byte, int64, error) {
+	client := s.client()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+mp3ChunkSize-1))
+
+	log.Printf("fetching start of %s", url)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	total := int64(-1)
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		cr := res.Header.Get("Content-Range")
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				total = n
+			}
+		}
+	case http.StatusOK:
+		// The server ignored the range, so skip to the offset ourselves
+		total = res.ContentLength
+		if _, err := io.CopyN(ioutil.Discard, res.Body, offset); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, mp3ChunkSize))
+	return b, total, err
+}
+
+// Work out how long the MP3 at url is from its first frame: exactly if it
+// has a VBR header saying how many frames there are, otherwise from its
+// length and bitrate. length is the file's size if already known
+func (s *Scraper) mp3Duration(ctx context.Context, url string, length int64) (time.Duration, error) {
+	b, total, err := s.getRange(ctx, url, 0)
+	if err != nil {
+		return 0, err
+	}
+	if length <= 0 {
+		length = total
+	}
+
+	// Skip the ID3 tag, fetching what follows it if it fills the chunk
+	offset := id3Size(b)
+	if offset > 0 {
+		if offset+1024 > len(b) {
+			if b, _, err = s.getRange(ctx, url, int64(offset)); err != nil {
+				return 0, err
+			}
+		} else {
+			b = b[offset:]
+		}
+	}
+
+	// Skip any padding before the first frame
+	for i := 0; i+4 <= len(b); i++ {
+		f, ok := parseFrame(b[i:])
+		if !ok {
+			continue
+		}
+		if len(b[i:]) < 4+32+18 {
+			break
+		}
+
+		if frames, ok := vbrFrames(b[i:], f); ok && frames > 0 {
+			secs := float64(frames) * float64(f.samplesPerFrame()) / float64(f.sampleRate)
+			return time.Duration(secs) * time.Second, nil
+		}
+		if length <= 0 {
+			return 0, errors.New("can't tell the length of a constant bitrate MP3")
+		}
+		audio := length - int64(offset) - int64(i)
+		return time.Duration(audio*8/int64(f.bitrate)) * time.Second, nil
+	}
+	return 0, errNoFrame
+}
@@ -0,0 +1,21 @@
+package scraper
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// Episode numbers as they appear in titles, e.g. "KCRW Broadcast 761",
+// "Episode 12" or "#12"
+var numberRE = regexp.MustCompile(`(?i)(?:\b(?:broadcast|episode|ep\.?|no\.)\s*#?\s*|#)(\d{1,6})\b`)
+
+// EpisodeNumber finds an episode's number in its title, 0 if it doesn't
+// have one
+func EpisodeNumber(title string) int {
+	m := numberRE.FindStringSubmatch(title)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
@@ -0,0 +1,105 @@
+package scraper
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// PageCache remembers show pages' ETags, Last-Modified dates and hashes
+// along with the episodes found on them, so a page that hasn't changed
+// isn't parsed again, or fetched at all if KCRW says so
+type PageCache struct {
+	mu    sync.Mutex
+	pages map[string]page
+}
+
+// What a page's episodes are cached under: its URL, and everything that
+// changes what's found in it, so that changing them (e.g. in a reload of
+// the configuration) has the page parsed again
+func (s *Scraper) pageKey() string {
+	loc := "UTC"
+	if s.Location != nil {
+		loc = s.Location.String()
+	}
+	return fmt.Sprintf("%s %+v %+v %s", s.URL, s.strategies(), s.Preference, loc)
+}
+
+// A show page as last scraped
+type page struct {
+	etag     string
+	modified string
+	hash     [sha256.Size]byte
+	episodes []Episode
+}
+
+// NewPageCache returns an empty PageCache
+func NewPageCache() *PageCache {
+	return &PageCache{pages: map[string]page{}}
+}
+
+func (c *PageCache) get(key string) (page, bool) {
+	if c == nil {
+		return page{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pages[key]
+	p.episodes = append([]Episode(nil), p.episodes...)
+	return p, ok
+}
+
+func (c *PageCache) set(key string, p page) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p.episodes = append([]Episode(nil), p.episodes...)
+	c.pages[key] = p
+}
+
+// Fetch the show page, asking only for changes since the copy in last.
+// Reports false, with no body, if it hasn't changed, whether KCRW says so
+// or it's the same as before
+func (s *Scraper) getPage(ctx context.Context, last page) (string, page, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return "", page{}, false, err
+	}
+	if last.etag != "" {
+		req.Header.Set("If-None-Match", last.etag)
+	}
+	if last.modified != "" {
+		req.Header.Set("If-Modified-Since", last.modified)
+	}
+
+	log.Printf("fetching url %s", s.URL)
+	res, err := s.client().Do(req)
+	if err != nil {
+		return "", page{}, false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && (last.etag != "" || last.modified != "") {
+		return "", last, false, nil
+	}
+	if res.StatusCode != 200 {
+		return "", page{}, false, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", page{}, false, err
+	}
+	p := page{etag: res.Header.Get("ETag"), modified: res.Header.Get("Last-Modified"), hash: sha256.Sum256(body)}
+	if p.hash == last.hash && last.episodes != nil {
+		last.etag, last.modified = p.etag, p.modified
+		return "", last, false, nil
+	}
+	return string(body), p, true, nil
+}
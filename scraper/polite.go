@@ -0,0 +1,101 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultUserAgent is what the scraper identifies itself as unless told
+// otherwise
+const DefaultUserAgent = "fanatic (+https://github.com/djl/fanatic)"
+
+// Pacer spaces requests out by at least Delay. Share one between Scrapers
+// so the delay holds across shows and refreshes. Change Delay with
+// SetDelay once it's in use
+type Pacer struct {
+	Delay time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewPacer returns a Pacer allowing a request every delay
+func NewPacer(delay time.Duration) *Pacer {
+	return &Pacer{Delay: delay}
+}
+
+// SetDelay changes the delay, from the next request on
+func (p *Pacer) SetDelay(delay time.Duration) {
+	p.mu.Lock()
+	p.Delay = delay
+	p.mu.Unlock()
+}
+
+// Wait blocks until the next request is allowed, or ctx is done
+func (p *Pacer) Wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	if p.Delay <= 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.Delay)
+	p.mu.Unlock()
+
+	if at.Equal(now) {
+		return nil
+	}
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The client every request is made with, sending the User-Agent and
+// waiting for the Pacer
+func (s *Scraper) client() *http.Client {
+	return &http.Client{Transport: Polite(s.Transport, s.UserAgent, s.Pacer)}
+}
+
+// Polite returns a RoundTripper making requests the way the Scraper does:
+// through next (http.DefaultTransport if nil), as userAgent
+// (DefaultUserAgent if empty), each waiting for pacer (which may be nil).
+// Give it everything else that fetches from KCRW too
+func Polite(next http.RoundTripper, userAgent string, pacer *Pacer) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return politeTransport{next, userAgent, pacer}
+}
+
+type politeTransport struct {
+	next      http.RoundTripper
+	userAgent string
+	pacer     *Pacer
+}
+
+func (t politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.pacer.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,415 @@
+// Package scraper pulls episodes of a KCRW show from its page on kcrw.com.
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/tidwall/gjson"
+)
+
+// DefaultURL is the show page for Henry Rollins' KCRW show
+const DefaultURL = "https://www.kcrw.com/music/shows/henry-rollins"
+
+// ErrNoEpisodes is returned when a show page yields no episodes at all,
+// which usually means KCRW changed its markup
+var ErrNoEpisodes = errors.New("no episodes found")
+
+// Episode is a single broadcast of the show
+type Episode struct {
+	Title    string        `json:"title"`
+	Link     string        `json:"link"`
+	MP3      string        `json:"mp3"`
+	UUID     string        `json:"uuid"`
+	PubDate  time.Time     `json:"pubdate"`
+	Duration time.Duration `json:"duration"`
+
+	// Number is the episode's number from its title (761 for "KCRW
+	// Broadcast 761"), 0 if it has none
+	Number int `json:"number,omitempty"`
+
+	// Description and Tracklist (songs played, "Artist - Title") are
+	// filled in if KCRW has them
+	Description string   `json:"description,omitempty"`
+	Tracklist   []string `json:"tracklist,omitempty"`
+
+	// Image is the URL of the episode's own artwork, if it has any
+	Image string `json:"image,omitempty"`
+
+	// MediaType and Length (in bytes) of the MP3, if the Scraper probed it
+	MediaType string `json:"media_type,omitempty"`
+	Length    int64  `json:"length,omitempty"`
+
+	// GUID is what the episode is known by in feeds, kept from the first
+	// feed it was in so it never changes. Its UUID if empty
+	GUID string `json:"guid,omitempty"`
+
+	// Source is the player JSON the episode was read from
+	Source []byte `json:"-"`
+}
+
+// MarshalJSON encodes the duration as seconds rather than nanoseconds
+func (e Episode) MarshalJSON() ([]byte, error) {
+	type episode Episode
+	return json.Marshal(struct {
+		episode
+		Duration int64 `json:"duration"`
+	}{episode(e), int64(e.Duration.Seconds())})
+}
+
+// UnmarshalJSON reads an episode written by MarshalJSON
+func (e *Episode) UnmarshalJSON(b []byte) error {
+	type episode Episode
+	v := struct {
+		*episode
+		Duration int64 `json:"duration"`
+	}{episode: (*episode)(e)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	e.Duration = time.Duration(v.Duration) * time.Second
+	return nil
+}
+
+// Selectors say where episodes are found in KCRW's markup: a CSS selector
+// for each episode's player button on the show page, the attribute on it
+// holding the URL of the episode's player JSON, and gjson paths for the
+// fields of the player JSON
+type Selectors struct {
+	Episode    string `json:"episode"`
+	PlayerAttr string `json:"player_attr"`
+
+	UUID     string `json:"uuid"`
+	Link     string `json:"link"`
+	Title    string `json:"title"`
+	MP3      string `json:"mp3"`
+	Duration string `json:"duration"`
+	Date     string `json:"date"`
+
+	// Media is an array of the episode's audio files, objects with url,
+	// format and bitrate fields, to pick the enclosure from (see
+	// MediaPreference). MP3 is only used if it has none
+	Media string `json:"media"`
+
+	Description string `json:"description"`
+
+	// Tracklist is an array of either strings or objects with artist and
+	// title fields
+	Tracklist string `json:"tracklist"`
+
+	// Image is the URL of the episode's artwork, resolved against its page
+	// if it's relative
+	Image string `json:"image"`
+
+	// DateLayout is the time.Parse layout of the date field. Dates
+	// without a time zone are taken to be in the Scraper's Location
+	DateLayout string `json:"date_layout"`
+}
+
+// DefaultSelectors match kcrw.com's markup
+var DefaultSelectors = Selectors{
+	Episode:     "div.four-col.hub-row.no-border button.audio",
+	PlayerAttr:  "data-player-json",
+	UUID:        "uuid",
+	Link:        "url",
+	Title:       "title",
+	MP3:         "media.0.url",
+	Duration:    "duration",
+	Date:        "date",
+	Media:       "media",
+	Description: "description",
+	Tracklist:   "tracklist",
+	Image:       "image",
+	DateLayout:  time.RFC3339,
+}
+
+// DefaultFallbacks loosen the episode selector bit by bit, so shuffled
+// layout classes don't lose the feed
+var DefaultFallbacks = []Selectors{
+	{Episode: "button.audio[data-player-json]"},
+	{Episode: "[data-player-json]"},
+}
+
+// Fill in empty fields from def
+func (s Selectors) merge(def Selectors) Selectors {
+	for _, f := range []struct{ v, def *string }{
+		{&s.Episode, &def.Episode},
+		{&s.PlayerAttr, &def.PlayerAttr},
+		{&s.UUID, &def.UUID},
+		{&s.Link, &def.Link},
+		{&s.Title, &def.Title},
+		{&s.MP3, &def.MP3},
+		{&s.Duration, &def.Duration},
+		{&s.Date, &def.Date},
+		{&s.Media, &def.Media},
+		{&s.Description, &def.Description},
+		{&s.Tracklist, &def.Tracklist},
+		{&s.Image, &def.Image},
+		{&s.DateLayout, &def.DateLayout},
+	} {
+		if *f.v == "" {
+			*f.v = *f.def
+		}
+	}
+	return s
+}
+
+// Scraper fetches episodes from a KCRW show page
+type Scraper struct {
+	// URL is the show page, e.g. DefaultURL
+	URL string
+
+	// Selectors locate episodes in the page. Empty fields fall back to
+	// DefaultSelectors
+	Selectors Selectors
+
+	// Fallbacks are tried in order when Selectors find no episodes. Their
+	// empty fields fall back to Selectors. DefaultFallbacks if nil
+	Fallbacks []Selectors
+
+	// Transport makes the requests, http.DefaultTransport if nil. Swap it
+	// out to record or replay responses (see the fixture package)
+	Transport http.RoundTripper
+
+	// Preference decides which of an episode's media becomes its
+	// enclosure
+	Preference MediaPreference
+
+	// Location is where the show is broadcast from. Publication dates
+	// without a time zone are read in its, UTC if nil
+	Location *time.Location
+
+	// Media, if set, has episodes' MP3s probed with HEAD requests for
+	// their type and length, remembering the answers
+	Media *MediaCache
+
+	// ResolveRedirects, if positive, replaces MP3 URLs which redirect
+	// (e.g. through a tracker to a CDN) with where they lead, resolving
+	// them again once the answer is this old. Needs Media
+	ResolveRedirects time.Duration
+
+	// UserAgent is sent with every request, DefaultUserAgent if empty
+	UserAgent string
+
+	// Pacer, if set, keeps a minimum delay between requests
+	Pacer *Pacer
+
+	// Pages, if set, has the show page fetched conditionally, with the
+	// episodes found last time returned if it hasn't changed (by KCRW's
+	// say or by its hash) and neither have the settings above they were
+	// found with
+	Pages *PageCache
+}
+
+// New returns a Scraper for the show page at url
+func New(url string) *Scraper {
+	return &Scraper{URL: url}
+}
+
+// Fetch given URL
+func (s *Scraper) get(ctx context.Context, url string) (string, error) {
+	client := s.client()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("fetching url %s", url)
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		err = fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+		return "", err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+// strategies returns the selectors to try, in order, with defaults filled in
+func (s *Scraper) strategies() []Selectors {
+	primary := s.Selectors.merge(DefaultSelectors)
+	fallbacks := s.Fallbacks
+	if fallbacks == nil {
+		fallbacks = DefaultFallbacks
+	}
+
+	strategies := []Selectors{primary}
+	for _, f := range fallbacks {
+		strategies = append(strategies, f.merge(primary))
+	}
+	return strategies
+}
+
+// Episodes gets the episodes listed on the show page, trying each of the
+// strategies until one finds some.
+// Errors will likely be either HTTP errors or HTML parsing errors
+// (e.g. the HTML changed and this needs to be rewritten accordingly)
+func (s *Scraper) Episodes() ([]Episode, error) {
+	return s.EpisodesContext(context.Background())
+}
+
+// EpisodesContext is Episodes with a context for its requests
+func (s *Scraper) EpisodesContext(ctx context.Context) ([]Episode, error) {
+	key := s.pageKey()
+	last, _ := s.Pages.get(key)
+	res, current, changed, err := s.getPage(ctx, last)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		log.Printf("%s unchanged, keeping its %d episodes", s.URL, len(last.episodes))
+		s.Pages.set(key, current)
+		return s.probed(ctx, current.episodes), nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(res))
+	if err != nil {
+		return nil, err
+	}
+
+	// Strategies often share player JSON, so only fetch each once
+	players := map[string]string{}
+	for i, sels := range s.strategies() {
+		episodes := s.find(ctx, doc, sels, players)
+		if len(episodes) > 0 {
+			if i > 0 {
+				log.Printf("primary selectors found no episodes, fallback %d found %d", i, len(episodes))
+			}
+			current.episodes = episodes
+			s.Pages.set(key, current)
+			return s.probed(ctx, episodes), nil
+		}
+	}
+
+	return nil, ErrNoEpisodes
+}
+
+// Find episodes in doc with the given selectors. Player JSON is cached in
+// players by URL
+func (s *Scraper) find(ctx context.Context, doc *goquery.Document, sels Selectors, players map[string]string) []Episode {
+	var episodes []Episode
+
+	doc.Find(sels.Episode).Each(func(i int, sel *goquery.Selection) {
+		jurl, exists := sel.Attr(sels.PlayerAttr)
+		if !exists {
+			return
+		}
+
+		json, ok := players[jurl]
+		if !ok {
+			res, err := s.get(ctx, jurl)
+			if err != nil {
+				return
+			}
+			json = res
+			players[jurl] = json
+		}
+
+		id := gjson.Get(json, sels.UUID).String()
+		link := gjson.Get(json, sels.Link).String()
+		title := cleanText(gjson.Get(json, sels.Title).String())
+		mp3, mediaType := s.Preference.pick(gjson.Get(json, sels.Media))
+		if mp3 == "" {
+			mp3 = gjson.Get(json, sels.MP3).String()
+		}
+
+		// Without these the JSON paths are probably wrong
+		if id == "" || mp3 == "" {
+			return
+		}
+
+		durstr := gjson.Get(json, sels.Duration).Int()
+		duration, err := time.ParseDuration(fmt.Sprintf("%ds", durstr))
+		if err != nil {
+			return
+		}
+
+		loc := s.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+		datestr := gjson.Get(json, sels.Date).String()
+		pubdate, err := time.ParseInLocation(sels.DateLayout, datestr, loc)
+		if err != nil {
+			return
+		}
+		pubdate = pubdate.In(loc)
+
+		episode := Episode{
+			Title:       title,
+			Number:      EpisodeNumber(title),
+			Link:        link,
+			MP3:         mp3,
+			UUID:        id,
+			PubDate:     pubdate,
+			Duration:    duration,
+			Description: strings.TrimSpace(gjson.Get(json, sels.Description).String()),
+			Tracklist:   tracklist(gjson.Get(json, sels.Tracklist)),
+			Image:       resolveURL(link, gjson.Get(json, sels.Image).String()),
+			MediaType:   mediaType,
+			Source:      []byte(json),
+		}
+
+		episodes = append(episodes, episode)
+	})
+
+	return episodes
+}
+
+// Read a tracklist of strings or {"artist", "title"} objects
+func tracklist(res gjson.Result) []string {
+	var tracks []string
+	for _, t := range res.Array() {
+		if !t.IsObject() {
+			if s := cleanText(t.String()); s != "" {
+				tracks = append(tracks, s)
+			}
+			continue
+		}
+
+		artist := cleanText(t.Get("artist").String())
+		title := cleanText(t.Get("title").String())
+		switch {
+		case artist != "" && title != "":
+			tracks = append(tracks, artist+" - "+title)
+		case artist != "" || title != "":
+			tracks = append(tracks, artist+title)
+		}
+	}
+	return tracks
+}
+
+// Resolve ref against base, giving "" unless the result is an absolute
+// http(s) URL
+func resolveURL(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	u, err := b.Parse(strings.TrimSpace(ref))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
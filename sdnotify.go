@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Tell systemd about fanatic's state (e.g. READY=1) over NOTIFY_SOCKET,
+// for services with Type=notify. Does nothing when not run by systemd
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// Abstract sockets are given with a leading @
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Send systemd's watchdog keepalives until ctx is done, if the service has
+// WatchdogSec set, as long as alive reports fanatic's working. Once it
+// isn't systemd is left to restart it
+func sdWatchdog(ctx context.Context, alive func() (string, bool)) {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	// Twice as often as systemd wants, so one late tick doesn't count
+	ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	defer ticker.Stop()
+	stopped := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if stuck, ok := alive(); !ok {
+			if !stopped {
+				log.Printf("refreshing %s is stuck, no longer telling systemd's watchdog fanatic's alive", stuck)
+				stopped = true
+			}
+			continue
+		}
+		stopped = false
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("error notifying systemd: %s", err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/djl/fanatic/server"
+	"github.com/djl/fanatic/trace"
+)
+
+// Serve the feed over HTTP, refreshing it on a schedule
+func cmdServe(args []string) error {
+	port := getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	tlsCert, tlsKey, redirectPort := getenv("TLS_CERT"), getenv("TLS_KEY"), getenv("HTTP_REDIRECT_PORT")
+	acmeHosts := getenv("ACME_HOSTS")
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := getenv("LISTEN")
+	flags.StringVar(&port, "port", port, "port to listen on")
+	flags.StringVar(&listen, "listen", listen, "comma separated addresses to listen on instead of -port, e.g. 127.0.0.1:8080, unix:/run/fanatic.sock or redirect::80")
+	flags.StringVar(&tlsCert, "tls-cert", tlsCert, "PEM certificate (chain) file to serve HTTPS with")
+	flags.StringVar(&tlsKey, "tls-key", tlsKey, "PEM private key file for -tls-cert")
+	flags.StringVar(&acmeHosts, "acme-hosts", acmeHosts, "serve HTTPS with certificates from Let's Encrypt for these comma separated host names")
+	flags.StringVar(&redirectPort, "redirect-port", redirectPort, "with -tls-cert or -acme-hosts, also listen on this port redirecting HTTP to HTTPS")
+	scrapeFlags(flags)
+	flags.Parse(args)
+
+	if errs := checkSettings(); len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key go together")
+	}
+	if tlsCert != "" && acmeHosts != "" {
+		return fmt.Errorf("-tls-cert and -acme-hosts can't both be used")
+	}
+
+	secure := tlsCert != "" || acmeHosts != ""
+	if listen == "" {
+		listen = ":" + port
+	}
+	if redirectPort != "" {
+		listen += ",redirect::" + redirectPort
+	}
+	addrs, err := parseListen(listen, secure)
+	if err != nil {
+		return err
+	}
+
+	sched, err := scheduleFromEnv()
+	if err != nil {
+		return err
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	exporter, err := trace.FromGetenv(getenv)
+	if err != nil {
+		return err
+	}
+	if exporter != nil {
+		log.Println("exporting traces to", exporter.Endpoint)
+		trace.Install(exporter)
+	}
+
+	if mirror, err = mirrorFromEnv(); err != nil {
+		return err
+	}
+	local := false
+	if mirror != nil {
+		_, local = mirror.Store.(server.DirStore)
+
+		// Know what's mirrored before building the first feeds
+		if err := mirror.Load(); err != nil {
+			log.Printf("error listing mirror: %s", err)
+		}
+	}
+
+	if transcripts, err = transcriptsFromEnv(); err != nil {
+		return err
+	}
+	if transcripts != nil {
+		if err := transcripts.Load(); err != nil {
+			log.Printf("error listing transcripts: %s", err)
+		}
+	}
+
+	// Enclosures point at /media/ to be proxied, or to be served from a
+	// mirror on disk
+	proxyMedia = getenv("MEDIA_PROXY") != "" || local
+	configured, err := configuredShows()
+	if err != nil {
+		return err
+	}
+
+	stateDir := getenv("STATE_DIR")
+	if stateDir != "" {
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			return err
+		}
+		unlock, err := lockStateDir(stateDir, "serve")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	running := &site{ctx: ctx, sched: sched, checker: linkCheckerFromEnv(), stateDir: stateDir, ready: make(chan struct{})}
+	running.mu.Lock()
+	err = running.start(configured)
+	running.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	go running.reloadOnHangup(ctx)
+
+	if exporter != nil {
+		go exporter.Run(ctx)
+	}
+
+	// Slow clients (slowloris and the like) are cut off. WriteTimeout is
+	// off by default, since feeds and media have their own limits
+	// (MEDIA_IDLE_TIMEOUT and MEDIA_DEADLINE)
+	srv := &http.Server{
+		Handler:           running,
+		ConnContext:       server.ConnContext,
+		ReadHeaderTimeout: envDuration("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       envDuration("READ_TIMEOUT", time.Minute),
+		WriteTimeout:      envDuration("WRITE_TIMEOUT", 0),
+		IdleTimeout:       envDuration("IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    envInt("MAX_HEADER_BYTES", 64<<10),
+	}
+
+	// Plain HTTP requests are sent to the HTTPS port
+	redirect := &http.Server{
+		Handler:           httpsRedirect(httpsPort(addrs)),
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		ReadTimeout:       srv.ReadTimeout,
+		IdleTimeout:       srv.IdleTimeout,
+		MaxHeaderBytes:    srv.MaxHeaderBytes,
+	}
+	if tlsCert != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if acmeHosts != "" {
+		m, err := acmeManager(acmeHosts)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+		// The redirecting addresses answer HTTP challenges too
+		redirect.Handler = m.HTTPHandler(redirect.Handler)
+	}
+
+	// A socket passed by systemd takes the place of the addresses serving
+	// the site
+	activated, err := activatedListener()
+	if err != nil {
+		return err
+	}
+	var ls []listening
+	if activated != nil {
+		ls = append(ls, listening{activated, srv, secure})
+	}
+	for _, a := range addrs {
+		if activated != nil && !a.redirect {
+			continue
+		}
+		l, err := listener(a.addr)
+		if err != nil {
+			for _, l := range ls {
+				l.l.Close()
+			}
+			return err
+		}
+		log.Println("listening on", a)
+		if a.redirect {
+			ls = append(ls, listening{l, redirect, false})
+		} else {
+			ls = append(ls, listening{l, srv, secure && !a.plain})
+		}
+	}
+
+	// Ready once there's a feed to serve, either loaded or from the first
+	// successful refresh, and alive while refreshes keep to schedule
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-running.ready:
+		}
+		if err := sdNotify("READY=1"); err != nil {
+			log.Printf("error notifying systemd: %s", err)
+		}
+		sdWatchdog(ctx, running.refreshing)
+	}()
+
+	// On SIGINT/SIGTERM stop accepting connections and give in-flight
+	// requests (e.g. a client halfway through downloading the feed) a
+	// chance to finish
+	err = serveAll(ctx, ls, tlsCert, tlsKey, envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+
+	// Send the spans of requests finished while shutting down
+	if exporter != nil {
+		if err := exporter.Flush(); err != nil {
+			log.Printf("error exporting spans: %s", err)
+		}
+	}
+	return err
+}
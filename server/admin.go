@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+var adminPage = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>fanatic admin</title>
+    <link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+    <h1>fanatic admin</h1>
+    <table>
+        <tr><th>show</th><th>status</th><th>episodes</th><th>last success</th><th>last error</th><th></th></tr>
+        {{- range .Shows}}
+        <tr>
+            <td>{{.Slug}}</td>
+            <td>{{if .Enabled}}{{.Health.Status}}{{else}}off{{end}}</td>
+            <td>{{.Health.Episodes}}</td>
+            <td>{{if .Health.LastSuccess.IsZero}}never{{else}}{{.Health.LastSuccess.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+            <td>{{.Health.LastError}}</td>
+            <td>
+                <form method="post" action="/admin/refresh"><input type="hidden" name="token" value="{{$.Token}}"><input type="hidden" name="show" value="{{.Slug}}"><button{{if not .Enabled}} disabled{{end}}>refresh</button></form>
+                <form method="post" action="/admin/toggle"><input type="hidden" name="token" value="{{$.Token}}"><input type="hidden" name="show" value="{{.Slug}}"><button>{{if .Enabled}}turn off{{else}}turn on{{end}}</button></form>
+            </td>
+        </tr>
+        {{- end}}
+    </table>
+    {{- if .Reload}}
+    <form method="post" action="/admin/reload"><input type="hidden" name="token" value="{{.Token}}"><button>reload config</button></form>
+    {{- end}}
+    <h2>log</h2>
+    <pre>{{range .Log}}{{.}}
+{{end}}</pre>
+</body>
+</html>
+`))
+
+type adminShow struct {
+	Slug    string
+	Enabled bool
+	Health  Health
+}
+
+// Serve the admin page at /admin, for refreshing shows, turning them off
+// and on, reloading the config (with reload, if set) and reading the recent
+// log without a shell on the server. It's behind auth, and its forms post
+// back auth's form token
+func adminHandler(shows []Show, auth Auth, logs *LogBuffer, cache CachePolicy, reload func() error) http.Handler {
+	token := auth.formToken()
+	find := func(slug string) (Show, bool) {
+		for _, sh := range shows {
+			if sh.Slug == slug {
+				return sh, true
+			}
+		}
+		return Show{}, false
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if req.URL.Path == "/admin" {
+			data := struct {
+				Token  string
+				Reload bool
+				Shows  []adminShow
+				Log    []string
+			}{Token: token, Reload: reload != nil}
+			for _, sh := range shows {
+				data.Shows = append(data.Shows, adminShow{sh.Slug, sh.State.Enabled(), sh.State.Health()})
+			}
+			if logs != nil {
+				data.Log = logs.Lines()
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			adminPage.Execute(w, data)
+			return
+		}
+
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, req, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !equal(req.PostFormValue("token"), token) {
+			httpError(w, req, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if req.URL.Path == "/admin/reload" && reload != nil {
+			log.Printf("[%s] reloading config from the admin page", requestID(req.Context()))
+			if err := reload(); err != nil {
+				log.Printf("error reloading config: %s", err)
+				httpError(w, req, "error reloading config: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, req, "/admin", http.StatusSeeOther)
+			return
+		}
+
+		sh, ok := find(req.PostFormValue("show"))
+		if !ok {
+			notFound(w, req)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/admin/refresh":
+			log.Printf("[%s] refreshing %s from the admin page", requestID(req.Context()), sh.Slug)
+			refreshNow(sh, cache)
+		case "/admin/toggle":
+			on := !sh.State.Enabled()
+			if on {
+				log.Printf("[%s] turning %s on from the admin page", requestID(req.Context()), sh.Slug)
+			} else {
+				log.Printf("[%s] turning %s off from the admin page", requestID(req.Context()), sh.Slug)
+			}
+			sh.State.SetEnabled(on)
+			if err := cache.Purge("feed"); err != nil {
+				log.Printf("error purging CDN cache: %s", err)
+			}
+		default:
+			notFound(w, req)
+			return
+		}
+		http.Redirect(w, req, "/admin", http.StatusSeeOther)
+	})
+}
+
+// Refresh a show outside its schedule, as RefreshLoop would
+func refreshNow(sh Show, cache CachePolicy) error {
+	changed, err := sh.State.Refresh()
+	if err != nil {
+		log.Printf("error generating XML: %s", err)
+	}
+	if changed {
+		if err := cache.Purge("feed"); err != nil {
+			log.Printf("error purging CDN cache: %s", err)
+		}
+	}
+	return err
+}
+
+// Refresh every show (or just ?show=<slug>) when POSTed to, e.g. by a
+// webhook, answering with each show's health afterwards
+func refreshHandler(shows []Show, cache CachePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, req, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		slug := req.URL.Query().Get("show")
+		res := map[string]Health{}
+		for _, sh := range shows {
+			if slug != "" && sh.Slug != slug {
+				continue
+			}
+			refreshNow(sh, cache)
+			res[sh.Slug] = sh.State.Health()
+		}
+		if len(res) == 0 {
+			notFound(w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Write v as indented JSON
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// The show picked by the request's show parameter, or the default
+func requestShow(shows []Show, req *http.Request) (Show, bool) {
+	slug := req.URL.Query().Get("show")
+	if slug == "" {
+		return shows[0], true
+	}
+	for _, sh := range shows {
+		if sh.Slug == slug {
+			return sh, true
+		}
+	}
+	return Show{}, false
+}
+
+// Which episodes a request wants
+type episodeFilter struct {
+	query    string
+	from, to time.Time
+	limit    int
+
+	// The year episodes were published in where they're broadcast from,
+	// as /rss/<year>.xml has it. Zero for any
+	year int
+}
+
+// Parse a date given as 2006-01-02 or RFC 3339
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Read q (words that must all appear in the title, description or
+// tracklist), from and to (inclusive dates) and limit from the query
+// string
+func parseFilter(q url.Values) (episodeFilter, error) {
+	f := episodeFilter{query: strings.ToLower(strings.TrimSpace(q.Get("q")))}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.from, err = parseDate(v); err != nil {
+			return f, fmt.Errorf("invalid from: %q", v)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.to, err = parseDate(v); err != nil {
+			return f, fmt.Errorf("invalid to: %q", v)
+		}
+		// a bare date means the whole of that day
+		if len(v) == len("2006-01-02") {
+			f.to = f.to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	f.limit, err = parseLimit(q)
+	return f, err
+}
+
+// Read the limit parameter, 0 if there isn't one
+func parseLimit(q url.Values) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid limit: %q", v)
+	}
+	return n, nil
+}
+
+// Read the query parameters the feed can be cut down with: limit, the
+// most recent episodes to include, year, to only include that year's
+// episodes, and since, a date to include episodes from. Reports whether
+// there were any
+func parseFeedFilter(q url.Values) (episodeFilter, bool, error) {
+	var f episodeFilter
+	var err error
+	if f.limit, err = parseLimit(q); err != nil {
+		return f, false, err
+	}
+
+	if v := q.Get("year"); v != "" {
+		year, err := strconv.Atoi(v)
+		if err != nil || year < 1 || year > 9999 {
+			return f, false, fmt.Errorf("invalid year: %q", v)
+		}
+		f.year = year
+	}
+	if v := q.Get("since"); v != "" {
+		if f.from, err = parseDate(v); err != nil {
+			return f, false, fmt.Errorf("invalid since: %q", v)
+		}
+	}
+	return f, f.limit > 0 || f.year > 0 || !f.from.IsZero(), nil
+}
+
+func (f episodeFilter) match(e scraper.Episode) bool {
+	if !f.from.IsZero() && e.PubDate.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && e.PubDate.After(f.to) {
+		return false
+	}
+	if f.year > 0 && e.PubDate.Year() != f.year {
+		return false
+	}
+	if f.query == "" {
+		return true
+	}
+
+	text := strings.ToLower(e.Title + "\n" + e.Description + "\n" + strings.Join(e.Tracklist, "\n"))
+	for _, word := range strings.Fields(f.query) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f episodeFilter) apply(episodes []scraper.Episode) []scraper.Episode {
+	matched := []scraper.Episode{}
+	for _, e := range episodes {
+		if f.limit > 0 && len(matched) == f.limit {
+			break
+		}
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// Serve a show's episodes as JSON at /api/episodes, filtered by the query
+// parameters parseFilter understands
+func episodesHandler(shows []Show, cache CachePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sh, ok := requestShow(shows, req)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no such show")
+			return
+		}
+		filter, err := parseFilter(req.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		_, episodes, err := sh.State.Get()
+		if err != nil && len(episodes) == 0 {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		cache.setHeaders(w, feedKeys(episodes)...)
+		writeJSON(w, http.StatusOK, filter.apply(episodes))
+	})
+}
+
+// Serve everything known about an episode at /api/episodes/<uuid>,
+// including the JSON it was scraped from. Every show is searched unless
+// the show parameter picks one
+func episodeHandler(shows []Show, cache CachePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := strings.TrimPrefix(req.URL.Path, "/api/episodes/")
+
+		search := shows
+		if req.URL.Query().Get("show") != "" {
+			sh, ok := requestShow(shows, req)
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, "no such show")
+				return
+			}
+			search = []Show{sh}
+		}
+
+		sh, e, ok := findEpisode(search, id)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no such episode")
+			return
+		}
+
+		// Episode marshals itself, so add to what it writes
+		b, err := json.Marshal(e)
+		if err != nil {
+			reportRequest(req, err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		detail := map[string]interface{}{}
+		json.Unmarshal(b, &detail)
+		detail["show"] = sh.Slug
+		if json.Valid(e.Source) {
+			detail["source"] = json.RawMessage(e.Source)
+		} else if len(e.Source) > 0 {
+			detail["source"] = string(e.Source)
+		}
+
+		cache.setHeaders(w, "feed", episodeKey(e))
+		writeJSON(w, http.StatusOK, detail)
+	})
+}
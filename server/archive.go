@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Serve the show's archive pages at <prefix><n>.xml
+func archiveHandler(sh Show, prefix string, cache CachePolicy, compact bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, prefix)
+		n, err := strconv.Atoi(strings.TrimSuffix(name, ".xml"))
+		if err != nil || !strings.HasSuffix(name, ".xml") || !sh.State.Enabled() {
+			notFound(w, req)
+			return
+		}
+
+		compact, err := xmlFormat(req, compact)
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, episodes, _ := sh.State.Get()
+		xml, ok := sh.Archive(episodes, n, compact)
+		if !ok {
+			notFound(w, req)
+			return
+		}
+		cache.setHeaders(w, feedKeys(episodes)...)
+		serveXML(w, req, xml, sh.State.LastChanged())
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Auth protects the admin and debugging routes. Requests need Token, as a
+// bearer token or the password of HTTP basic auth (with any user name), or
+// User and Password. With neither set nothing is protected, and the routes
+// that change anything are turned off
+type Auth struct {
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Enabled reports whether any credentials are set
+func (a Auth) Enabled() bool {
+	return a.Token != "" || a.User != "" && a.Password != ""
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Whether the request has the credentials
+func (a Auth) allows(req *http.Request) bool {
+	user, pass, basic := req.BasicAuth()
+	if a.Token != "" {
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if basic {
+			given = pass
+		}
+		if equal(given, a.Token) {
+			return true
+		}
+	}
+	return a.User != "" && a.Password != "" && basic && equal(user, a.User) && equal(pass, a.Password)
+}
+
+// A value derived from the credentials for forms to post back, so other
+// sites can't submit them with the browser's saved credentials
+func (a Auth) formToken() string {
+	sum := sha256.Sum256([]byte("fanatic-form\x00" + a.Token + "\x00" + a.User + "\x00" + a.Password))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Ask for credentials before passing requests on to next
+func (a Auth) wrap(next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !a.allows(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fanatic"`)
+			httpError(w, req, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BroadcastSchedule polls every Fast for Window after the weekly broadcast
+// ends, when a new episode is likely to show up, and every Slow the rest
+// of the week
+type BroadcastSchedule struct {
+	Weekday  time.Weekday
+	Start    time.Duration // offset of the broadcast from midnight
+	Location *time.Location
+	Length   time.Duration
+	Window   time.Duration
+	Fast     time.Duration
+	Slow     time.Duration
+}
+
+// ParseBroadcastTime parses a broadcast slot such as "Sat 20:00"
+func ParseBroadcastTime(s string) (time.Weekday, time.Duration, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected \"<day> <HH:MM>\", got %q", s)
+	}
+
+	day := -1
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if len(fields[0]) >= 3 && strings.HasPrefix(strings.ToLower(d.String()), strings.ToLower(fields[0])) {
+			day = int(d)
+		}
+	}
+	if day < 0 {
+		return 0, 0, fmt.Errorf("unknown day %q", fields[0])
+	}
+
+	t, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Weekday(day), time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// The end of the most recent broadcast at or before t
+func (s BroadcastSchedule) lastEnd(t time.Time) time.Time {
+	t = t.In(s.Location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.Location)
+	day = day.AddDate(0, 0, int(s.Weekday-t.Weekday()))
+	end := day.Add(s.Start + s.Length)
+	if end.After(t) {
+		end = day.AddDate(0, 0, -7).Add(s.Start + s.Length)
+	}
+	return end
+}
+
+func (s BroadcastSchedule) Next(t time.Time) time.Time {
+	last := s.lastEnd(t)
+	if t.Sub(last) < s.Window {
+		return t.Add(s.Fast)
+	}
+
+	next := t.Add(s.Slow)
+	upcoming := s.lastEnd(last.AddDate(0, 0, 8))
+	if upcoming.Before(next) {
+		return upcoming
+	}
+	return next
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// CachePolicy says how responses should be cached by clients and by a CDN
+// in front of fanatic. Shared caches get a long TTL and are purged by
+// surrogate key whenever the feed changes, so they never need to
+// revalidate on their own.
+type CachePolicy struct {
+	MaxAge     time.Duration // browsers and podcast clients
+	CDNMaxAge  time.Duration // shared caches (s-maxage)
+	PurgeURL   string
+	PurgeToken string
+}
+
+// CDNs cap the size of the key headers (16KB for Fastly and Cloudflare),
+// which a long archive's keys would go over
+const maxEpisodeKeys = 100
+
+// Surrogate keys for a feed response: one for the feed itself and one per
+// episode, up to maxEpisodeKeys of the first (newest) ones, so purging a
+// recent episode also drops any feed listing it. Purging "feed" drops the
+// rest
+func feedKeys(episodes []scraper.Episode) []string {
+	if len(episodes) > maxEpisodeKeys {
+		episodes = episodes[:maxEpisodeKeys]
+	}
+	keys := []string{"feed"}
+	for _, episode := range episodes {
+		keys = append(keys, episodeKey(episode))
+	}
+	return keys
+}
+
+func episodeKey(episode scraper.Episode) string {
+	return "episode-" + episode.UUID
+}
+
+// Set Cache-Control plus the surrogate key headers understood by Fastly
+// (Surrogate-Key, space separated) and Cloudflare (Cache-Tag, comma separated)
+func (c CachePolicy) setHeaders(w http.ResponseWriter, keys ...string) {
+	w.Header().Set("Cache-Control", fmt.Sprintf(
+		"public, max-age=%d, s-maxage=%d, stale-while-revalidate=60, stale-if-error=86400",
+		int(c.MaxAge.Seconds()), int(c.CDNMaxAge.Seconds())))
+	if len(keys) > 0 {
+		w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+		w.Header().Set("Cache-Tag", strings.Join(keys, ","))
+	}
+}
+
+// Purge asks the CDN to drop everything tagged with the given keys. The
+// request carries the keys both as a Surrogate-Key header and a {"tags": [...]}
+// body, with the token as Fastly-Key and a bearer token, which covers the
+// Fastly and Cloudflare purge APIs
+func (c CachePolicy) Purge(keys ...string) error {
+	if c.PurgeURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.PurgeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	if c.PurgeToken != "" {
+		req.Header.Set("Fastly-Key", c.PurgeToken)
+		req.Header.Set("Authorization", "Bearer "+c.PurgeToken)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("purge failed: %s", res.Status)
+	}
+
+	log.Printf("purged cache keys %s", strings.Join(keys, " "))
+	return nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For headers
+// are believed, so requests through them are put down to the client
+// rather than the proxy
+type TrustedProxies struct {
+	nets []*net.IPNet
+
+	// Whether whatever connects over a Unix domain socket is a proxy
+	unix bool
+}
+
+// ParseTrustedProxies reads a comma separated list of IP addresses and
+// CIDR blocks, e.g. "10.0.0.0/8,127.0.0.1", and "unix" for whatever
+// connects over a Unix domain socket
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	var t TrustedProxies
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "unix":
+			t.unix = true
+			continue
+		case !strings.Contains(entry, "/"):
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return TrustedProxies{}, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		t.nets = append(t.nets, n)
+	}
+	return t, nil
+}
+
+// The IP in a RemoteAddr, nil for a Unix domain socket
+func remoteIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+func (t TrustedProxies) trusts(ip net.IP) bool {
+	if ip == nil {
+		return t.unix
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The client behind the request: the last address in X-Forwarded-For
+// (or X-Real-IP) not added by a trusted proxy, if the request came
+// through one, or the connection's address otherwise
+func (t TrustedProxies) client(req *http.Request) string {
+	ip := remoteIP(req.RemoteAddr)
+	if !t.trusts(ip) {
+		return req.RemoteAddr
+	}
+
+	var hops []string
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) == 0 && req.Header.Get("X-Real-IP") != "" {
+		hops = []string{req.Header.Get("X-Real-IP")}
+	}
+
+	client := req.RemoteAddr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		client = hop.String()
+		if !t.trusts(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// Put the real client's address in RemoteAddr, for everything after to
+// log and count
+func withClientIP(t TrustedProxies, next http.Handler) http.Handler {
+	if len(t.nets) == 0 && !t.unix {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if client := t.client(req); client != req.RemoteAddr {
+			req = req.Clone(req.Context())
+			req.RemoteAddr = client
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ClientIP returns the address of the client making req, without a port
+func ClientIP(req *http.Request) string {
+	if ip := remoteIP(req.RemoteAddr); ip != nil {
+		return ip.String()
+	}
+	if req.RemoteAddr == "" || req.RemoteAddr == "@" {
+		return "unix"
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Whether a request wants its feed compact (?xml=compact) or indented
+// (?xml=pretty), or as feeds are built (compact or not) if it doesn't say
+func xmlFormat(req *http.Request, compact bool) (bool, error) {
+	switch req.URL.Query().Get("xml") {
+	case "":
+		return compact, nil
+	case "compact":
+		return true, nil
+	case "pretty":
+		return false, nil
+	}
+	return false, errors.New("invalid xml: want compact or pretty")
+}
+
+// Serve an RSS feed
+func serveXML(w http.ResponseWriter, req *http.Request, xml string, modified time.Time) {
+	serveFeed(w, req, xml, "text/xml", modified)
+}
+
+// Serve a feed of the given type with an ETag of its content and
+// Last-Modified of when it changed, so clients polling it (often with
+// HEAD) can tell whether it's worth downloading. Conditional and HEAD
+// requests get no body
+func serveFeed(w http.ResponseWriter, req *http.Request, body, contentType string, modified time.Time) {
+	sum := sha1.Sum([]byte(body))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	feedsServed.Add(1)
+	http.ServeContent(w, req, "", modified, strings.NewReader(body))
+}
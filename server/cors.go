@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS lets pages on other sites read the JSON API from the browser. With
+// no Origins nothing is sent, and browsers keep the API to this site
+type CORS struct {
+	// Origins allowed, e.g. "https://player.example.com", or "*" for any
+	Origins []string `json:"origins"`
+
+	// Methods allowed, GET, HEAD and OPTIONS if empty
+	Methods []string `json:"methods"`
+
+	// MaxAge is how many seconds browsers may remember a preflight's
+	// answer for, their default if zero
+	MaxAge int `json:"max_age"`
+}
+
+// The Access-Control-Allow-Origin value for origin, "" if it isn't allowed
+func (c CORS) allowOrigin(origin string) string {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// Add CORS headers to responses from next, and answer preflight requests
+func (c CORS) wrap(next http.Handler) http.Handler {
+	if len(c.Origins) == 0 {
+		return next
+	}
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		allow := c.allowOrigin(origin)
+		if origin == "" || allow == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", allow)
+		if req.Method != "OPTIONS" || req.Header.Get("Access-Control-Request-Method") == "" {
+			h.Set("Access-Control-Expose-Headers", "ETag, Last-Modified, Retry-After")
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>{{.Code}} {{.Status}} - fanatic!</title>
+    <link rel="stylesheet" href="/static/style.css">
+    <link rel="icon" href="/static/favicon.svg" type="image/svg+xml">
+</head>
+<body>
+    <h1>{{.Code}} {{.Status}}</h1>
+    {{- if ne .Message .Status}}
+    <p>{{.Message}}</p>
+    {{- end}}
+    <p><a href="/">back to the episodes</a></p>
+</body>
+</html>
+`))
+
+// Answer with an error: a page styled like the rest of the site for
+// browsers, and msg as plain text for everything else (feed readers,
+// scripts)
+func httpError(w http.ResponseWriter, req *http.Request, msg string, code int) {
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		http.Error(w, msg, code)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	errorPage.Execute(w, struct {
+		Code            int
+		Status, Message string
+	}{code, http.StatusText(code), msg})
+}
+
+// Answer with a 404
+func notFound(w http.ResponseWriter, req *http.Request) {
+	httpError(w, req, "Not Found", http.StatusNotFound)
+}
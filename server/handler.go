@@ -0,0 +1,314 @@
+// Package server serves generated feeds over HTTP and keeps them fresh.
+package server
+
+import (
+	"expvar"
+	"html/template"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+	"github.com/djl/fanatic/trace"
+)
+
+// Options configures the handler returned by NewHandler
+type Options struct {
+	Cache CachePolicy
+
+	// Limits on concurrent requests overall and on downloads of the feed
+	// and media; past them requests get a 503 asking clients to come back
+	// after RetryAfter. Zero means no limit
+	MaxRequests int
+	MaxStreams  int
+	RetryAfter  time.Duration
+
+	// RateLimit is how many requests a minute each client (by IP) may make
+	// of the feeds and the API, in bursts of up to RateBurst. Past it they
+	// get a 429. Zero means no limit
+	RateLimit float64
+	RateBurst int
+
+	// Downloads are cut off when the client stops reading for
+	// MediaIdleTimeout or they take longer than MediaDeadline
+	MediaIdleTimeout time.Duration
+	MediaDeadline    time.Duration
+
+	// MediaTransport fetches the MP3s streamed from /media/<uuid>.mp3,
+	// http.DefaultTransport if nil
+	MediaTransport http.RoundTripper
+
+	// Mirror, if set, has local copies of MP3s for /media/ to serve.
+	// MediaRedirect sends requests for those it doesn't have to KCRW
+	// instead of streaming them
+	Mirror        *Mirror
+	MediaRedirect bool
+
+	// LandingTemplate renders the page at /, the built in one if nil
+	LandingTemplate *template.Template
+
+	// Version of the build running, and the commit it was built from and
+	// when, reported by /status
+	Version string
+	Commit  string
+	Built   string
+
+	// Auth protects /admin, /refresh, /status and /debug/, which are only
+	// there at all when it's set. Logs has the recent log output the admin
+	// page shows. May be nil
+	Auth Auth
+	Logs *LogBuffer
+
+	// Reload, if set, re-reads the configuration, for the admin page's
+	// reload button
+	Reload func() error
+
+	// Pprof serves net/http/pprof's profiles at /debug/pprof/, behind Auth
+	// (and not at all without it)
+	Pprof bool
+
+	// Reporter is told about panics and other server errors. May be nil
+	Reporter ErrorReporter
+
+	// TrustedProxies are believed about who their requests are from, so
+	// it's the client that's logged rather than the proxy
+	TrustedProxies TrustedProxies
+
+	// CORS lets other sites' pages read /api/ from the browser
+	CORS CORS
+
+	// CompactXML says feeds are built without indentation, to save
+	// bandwidth. Either way a request can ask for ?xml=compact or
+	// ?xml=pretty, and gets the feed built again that way
+	CompactXML bool
+
+	// Transcripts, if set, has episodes' transcripts for /transcripts/ to
+	// serve
+	Transcripts *Transcripts
+
+	// StaticDir has files overriding the built in ones at /static/ (see
+	// Assets)
+	StaticDir string
+
+	// FeedPath is where the default show's feed is served, one of
+	// FeedPaths, "/rss.xml" if empty. The others redirect to it
+	FeedPath string
+
+	// BaseURL is the URL the site is publicly reached at, e.g. when it's
+	// behind a reverse proxy, for absolute links on the landing page and
+	// in the OPML. Without it the landing page's links are relative and
+	// the OPML's are to the host requests were made to
+	BaseURL string
+}
+
+// FeedPaths are the paths the default show's feed can be found at. All but
+// Options.FeedPath redirect to it permanently, so subscriptions made at any
+// of them keep working when it moves
+var FeedPaths = []string{"/rss.xml", "/feed", "/podcast.rss", "/index.xml"}
+
+// Show is a feed served at /shows/<Slug>/rss.xml, with feeds of each
+// year's episodes at /shows/<Slug>/rss/<year>.xml
+type Show struct {
+	Slug  string
+	State *State
+
+	// Archive builds page n of the feed's RFC 5005 archives, served at
+	// /shows/<Slug>/archive/<n>.xml, compact or indented, reporting false
+	// if there's no such page. May be nil
+	Archive func(episodes []scraper.Episode, n int, compact bool) (string, bool)
+
+	// Render builds a feed of just the given episodes, compact or
+	// indented, for requests asking for part of the feed. May be nil
+	Render func(episodes []scraper.Episode, compact bool) (string, error)
+
+	// Build builds the feed again from all its episodes, for requests
+	// asking for it compact or indented when it wasn't built that way.
+	// May be nil
+	Build func(episodes []scraper.Episode, compact bool) (string, error)
+
+	// Formats the feed is also served as, at the same URL, to requests
+	// whose Accept header prefers them, e.g. application/atom+xml. Current
+	// picks the episodes the feed has out of all of them. May be nil
+	Formats Formats
+	Current func(episodes []scraper.Episode) []scraper.Episode
+}
+
+// Path returns where the show's feed is served
+func (s Show) Path() string {
+	return "/shows/" + s.Slug + "/rss.xml"
+}
+
+// NewHandler builds the HTTP handler serving the site for shows. The first
+// show is the default, also served at opts.FeedPath (and its years at
+// /rss/<year>.xml)
+func NewHandler(shows []Show, opts Options) http.Handler {
+	cache := opts.Cache
+	feedPath := opts.FeedPath
+	if feedPath == "" {
+		feedPath = FeedPaths[0]
+	}
+	requests := newLimiter("requests", opts.MaxRequests, opts.RetryAfter)
+	streams := newLimiter("streams", opts.MaxStreams, opts.RetryAfter)
+	rate := newRateLimiter("requests", opts.RateLimit, opts.RateBurst)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			notFound(w, req)
+			return
+		}
+
+		_, episodes, _ := shows[0].State.Get()
+		data := Page{Episodes: episodes, Updated: shows[0].State.LastUpdated(), Base: opts.BaseURL}
+		for i, sh := range shows {
+			xml, _, _ := sh.State.Get()
+			title, _ := channelInfo(xml)
+			url := sh.Path()
+			if i == 0 {
+				url = feedPath
+			}
+			data.Feeds = append(data.Feeds, PageFeed{sh.Slug, title, opts.BaseURL + url})
+		}
+
+		page, err := LandingPage(opts.LandingTemplate, data)
+		if err != nil {
+			reportRequest(req, err)
+			httpError(w, req, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		cache.setHeaders(w, append([]string{"page"}, feedKeys(episodes)...)...)
+		w.Write(page)
+	})
+
+	feed := func(sh Show) http.Handler {
+		return rate.wrap(streams.wrap(withWriteDeadline(opts.MediaIdleTimeout, opts.MediaDeadline, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !sh.State.Enabled() {
+				notFound(w, req)
+				return
+			}
+
+			// After a failed refresh the last good feed is still served.
+			// Until there's been one clients are asked to come back later
+			xml, episodes, err := sh.State.Get()
+			if xml == "" {
+				msg := "Service Unavailable: no feed yet"
+				if err != nil {
+					msg += ": " + err.Error()
+				}
+				unavailable(w, req, opts.RetryAfter, msg)
+				return
+			}
+
+			filter, filtered, err := parseFeedFilter(req.URL.Query())
+			if err != nil {
+				httpError(w, req, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if len(sh.Formats) > 0 {
+				w.Header().Add("Vary", "Accept")
+			}
+			if format := negotiate(req.Header.Get("Accept"), sh.Formats); format != "" {
+				build := sh.Formats[format]
+				var body string
+				if filtered {
+					body, err = build(filter.apply(episodes))
+				} else {
+					body, err = sh.State.Formatted(format, func(episodes []scraper.Episode) (string, error) {
+						if sh.Current != nil {
+							episodes = sh.Current(episodes)
+						}
+						return build(episodes)
+					})
+				}
+				if err != nil {
+					reportRequest(req, err)
+					httpError(w, req, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				cache.setHeaders(w, feedKeys(episodes)...)
+				serveFeed(w, req, body, format+"; charset=utf-8", sh.State.LastChanged())
+				return
+			}
+
+			compact, err := xmlFormat(req, opts.CompactXML)
+			if err != nil {
+				httpError(w, req, err.Error(), http.StatusBadRequest)
+				return
+			}
+			switch {
+			case filtered && sh.Render != nil:
+				xml, err = sh.Render(filter.apply(episodes), compact)
+			case compact != opts.CompactXML && sh.Build != nil:
+				xml, err = sh.Build(episodes, compact)
+			}
+			if err != nil {
+				reportRequest(req, err)
+				httpError(w, req, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			cache.setHeaders(w, feedKeys(episodes)...)
+			serveXML(w, req, xml, sh.State.LastChanged())
+		}))))
+	}
+
+	years := func(sh Show, prefix string) {
+		if sh.Render != nil {
+			mux.Handle(prefix, rate.wrap(streams.wrap(withWriteDeadline(opts.MediaIdleTimeout, opts.MediaDeadline, yearHandler(sh, prefix, cache, opts.CompactXML)))))
+		}
+	}
+
+	mux.Handle(feedPath, feed(shows[0]))
+	for _, p := range FeedPaths {
+		if p != feedPath {
+			mux.Handle(p, redirectHandler(feedPath))
+		}
+	}
+	years(shows[0], "/rss/")
+	for _, sh := range shows {
+		mux.Handle(sh.Path(), feed(sh))
+		years(sh, "/shows/"+sh.Slug+"/rss/")
+		if sh.Archive != nil {
+			prefix := "/shows/" + sh.Slug + "/archive/"
+			mux.Handle(prefix, rate.wrap(streams.wrap(withWriteDeadline(opts.MediaIdleTimeout, opts.MediaDeadline, archiveHandler(sh, prefix, cache, opts.CompactXML)))))
+		}
+	}
+
+	mux.Handle("/media/", streams.wrap(withWriteDeadline(opts.MediaIdleTimeout, opts.MediaDeadline, mediaHandler(shows, cache, opts.MediaTransport, opts.Mirror, opts.MediaRedirect))))
+	mux.Handle("/static/", staticHandler(Assets(opts.StaticDir), cache))
+	mux.Handle("/transcripts/", rate.wrap(transcriptHandler(opts.Transcripts, cache)))
+	mux.Handle("/api/episodes", opts.CORS.wrap(rate.wrap(episodesHandler(shows, cache))))
+	mux.Handle("/api/episodes/", opts.CORS.wrap(rate.wrap(episodeHandler(shows, cache))))
+	mux.Handle("/opml.xml", opmlHandler(shows, opts.BaseURL))
+	mux.Handle("/healthz", healthHandler(shows))
+	if opts.Auth.Enabled() {
+		mux.Handle("/status", opts.Auth.wrap(statusHandler(shows, opts.Version, opts.Commit, opts.Built, time.Now())))
+		mux.Handle("/debug/vars", opts.Auth.wrap(expvar.Handler()))
+		admin := opts.Auth.wrap(adminHandler(shows, opts.Auth, opts.Logs, cache, opts.Reload))
+		mux.Handle("/admin", admin)
+		mux.Handle("/admin/", admin)
+		mux.Handle("/refresh", opts.Auth.wrap(refreshHandler(shows, cache)))
+		if opts.Pprof {
+			mux.Handle("/debug/pprof/", opts.Auth.wrap(http.HandlerFunc(pprof.Index)))
+			mux.Handle("/debug/pprof/cmdline", opts.Auth.wrap(http.HandlerFunc(pprof.Cmdline)))
+			mux.Handle("/debug/pprof/profile", opts.Auth.wrap(http.HandlerFunc(pprof.Profile)))
+			mux.Handle("/debug/pprof/symbol", opts.Auth.wrap(http.HandlerFunc(pprof.Symbol)))
+			mux.Handle("/debug/pprof/trace", opts.Auth.wrap(http.HandlerFunc(pprof.Trace)))
+		}
+	}
+
+	return withClientIP(opts.TrustedProxies, withRequestID(withReporter(opts.Reporter, withRecover(trace.Handler(requests.wrap(mux))))))
+}
+
+// Permanently redirect requests to path, keeping their query
+func redirectHandler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		u := path
+		if req.URL.RawQuery != "" {
+			u += "?" + req.URL.RawQuery
+		}
+		http.Redirect(w, req, u, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Health summarises how recent refreshes went
+type Health struct {
+	// Status is "ok" when the last refresh worked, "degraded" after a
+	// failure (or while any enclosures are dead) and "failing" once
+	// Monitor.Threshold refreshes in a row have failed
+	Status string `json:"status"`
+
+	// Refreshes in a row that failed, and how many of those found no
+	// episodes (usually meaning KCRW changed its markup)
+	Failures int `json:"consecutive_failures"`
+	Empty    int `json:"consecutive_empty"`
+
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	Episodes    int       `json:"episodes"`
+
+	// Episodes whose MP3s were missing when last checked
+	DeadEnclosures []DeadLink `json:"dead_enclosures,omitempty"`
+}
+
+// Monitor raises the alarm when refreshes keep failing
+type Monitor struct {
+	// Threshold is how many refreshes in a row must fail before alerting,
+	// 1 if zero
+	Threshold int
+
+	// Alert is called when the threshold is reached and again when a
+	// refresh next succeeds. May be nil
+	Alert func(Health)
+}
+
+func (m Monitor) threshold() int {
+	if m.Threshold < 1 {
+		return 1
+	}
+	return m.Threshold
+}
+
+// Record the outcome of a refresh, returning whether the monitor should
+// alert. Called with s.mu held
+func (s *State) record(err error) bool {
+	before := s.failures
+	s.err = err
+	if err == nil {
+		s.failures, s.empty = 0, 0
+		return before >= s.Monitor.threshold()
+	}
+
+	s.failures++
+	if errors.Is(err, scraper.ErrNoEpisodes) {
+		s.empty++
+	} else {
+		s.empty = 0
+	}
+	return s.failures == s.Monitor.threshold()
+}
+
+// Health reports how recent refreshes went
+func (s *State) Health() Health {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.health()
+}
+
+func (s *State) health() Health {
+	h := Health{
+		Status:      "ok",
+		Failures:    s.failures,
+		Empty:       s.empty,
+		LastSuccess: s.updated,
+		Episodes:    len(s.episodes),
+
+		DeadEnclosures: s.dead,
+	}
+	if s.err != nil {
+		h.LastError = s.err.Error()
+	}
+	switch {
+	case s.failures >= s.Monitor.threshold():
+		h.Status = "failing"
+	case s.failures > 0, len(s.dead) > 0:
+		h.Status = "degraded"
+	}
+	return h
+}
+
+var severity = map[string]int{"ok": 0, "degraded": 1, "failing": 2}
+
+// Serve the default show's health as JSON, along with every show's under
+// "shows". The status is the worst of any show's, with a 503 while it's
+// failing so uptime checkers notice
+func healthHandler(shows []Show) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		res := struct {
+			Health
+			Shows map[string]Health `json:"shows"`
+		}{shows[0].State.Health(), map[string]Health{}}
+		for _, sh := range shows {
+			h := sh.State.Health()
+			res.Shows[sh.Slug] = h
+			if severity[h.Status] > severity[res.Status] {
+				res.Status = h.Status
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if res.Status == "failing" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Page is what the landing page's template is executed with
+type Page struct {
+	// Episodes of the default show, newest first
+	Episodes []scraper.Episode
+
+	// Feeds served, the default show's first
+	Feeds []PageFeed
+
+	// Updated is when the default show's feed was last refreshed
+	Updated time.Time
+
+	// Base is the URL the site is public at, for absolute links. Empty if
+	// that isn't known
+	Base string
+}
+
+// PageFeed is a feed listed on the landing page
+type PageFeed struct {
+	Slug  string
+	Title string
+	URL   string
+}
+
+// Functions available to landing page templates, on top of the standard
+// ones: duration formats a time.Duration as H:MM:SS
+var landingFuncs = template.FuncMap{
+	"duration": func(d time.Duration) string {
+		total := int(d.Seconds())
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+	},
+}
+
+var landingPage = template.Must(template.New("landing").Funcs(landingFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>fanatic!</title>
+    <link rel="stylesheet" href="{{.Base}}/static/style.css">
+    <link rel="icon" href="{{.Base}}/static/favicon.svg" type="image/svg+xml">
+</head>
+<body>
+    <h1>fanatic!</h1>
+    <p>providing an <a href="{{(index .Feeds 0).URL}}">RSS feed</a> for Henry Rollins' <a href="https://www.kcrw.com/music/shows/henry-rollins">KCRW show</a> (because they don't)</p>
+    {{- if .Episodes}}
+    <ul>
+        {{- range .Episodes}}
+        <li><time datetime="{{.PubDate.Format "2006-01-02"}}">{{.PubDate.Format "2006-01-02"}}</time> <a href="{{.MP3}}">{{.Title}}</a>{{if .Duration}} <span class="duration">{{duration .Duration}}</span>{{end}}</li>
+        {{- end}}
+    </ul>
+    {{- end}}
+    <footer>n.b. none of the shows are hosted here. be cool ~<a href="https://djl.io/">author</a></footer>
+</body>
+</html>
+`))
+
+// ParseLandingTemplate reads an html/template file to use for the landing
+// page instead of the built in one. It's executed with a Page
+func ParseLandingTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(landingFuncs).ParseFiles(path)
+}
+
+// LandingPage renders the HTML served at / with t, or the built in
+// template listing the episodes with links to their MP3s if t is nil
+func LandingPage(t *template.Template, page Page) ([]byte, error) {
+	if t == nil {
+		t = landingPage
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Caps how many requests a handler serves at once. Past the cap requests
+// are turned away with a 503 and Retry-After rather than queueing up and
+// slowing everything down
+type limiter struct {
+	slots      chan struct{}
+	retryAfter time.Duration
+	inFlight   *expvar.Int
+	rejected   *expvar.Int
+}
+
+// Create a limiter allowing n concurrent requests (unlimited if n <= 0),
+// publishing its saturation as <name>_in_flight, <name>_limit and
+// <name>_rejected
+func newLimiter(name string, n int, retryAfter time.Duration) *limiter {
+	l := &limiter{
+		retryAfter: retryAfter,
+		inFlight:   counter(name + "_in_flight"),
+		rejected:   counter(name + "_rejected"),
+	}
+	counter(name + "_limit").Set(int64(n))
+	if n > 0 {
+		l.slots = make(chan struct{}, n)
+	}
+	return l
+}
+
+func (l *limiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			default:
+				l.rejected.Add(1)
+				unavailable(w, req, l.retryAfter, "Service Unavailable")
+				return
+			}
+		}
+
+		l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Turn a request away with a 503, asking the client to come back after
+// retryAfter (a minute if zero). Nothing about it is to be cached
+func unavailable(w http.ResponseWriter, req *http.Request, retryAfter time.Duration, msg string) {
+	if retryAfter <= 0 {
+		retryAfter = time.Minute
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	w.Header().Set("Cache-Control", "no-store")
+	httpError(w, req, msg, http.StatusServiceUnavailable)
+}
+
+// Gives each client a bucket of burst requests, refilled at perMinute a
+// minute. Clients with an empty bucket are turned away with a 429 until a
+// request's worth has dripped back in
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+	limited   *expvar.Int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Create a rateLimiter allowing each client perMinute requests a minute,
+// in bursts of up to burst (at least one). Nothing is limited if
+// perMinute <= 0. Turned away requests are counted as <name>_rate_limited
+func newRateLimiter(name string, perMinute float64, burst int) *rateLimiter {
+	l := &rateLimiter{limited: counter(name + "_rate_limited")}
+	if perMinute <= 0 {
+		return l
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l.perSecond, l.burst = perMinute/60, float64(burst)
+	l.buckets = map[string]*bucket{}
+	return l
+}
+
+// Take a token from client's bucket, or report how long until there's one
+func (l *rateLimiter) take(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Buckets that have filled back up are no different from new ones
+	if now.Sub(l.lastSweep) > time.Minute {
+		for c, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.perSecond >= l.burst {
+				delete(l.buckets, c)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) wrap(next http.Handler) http.Handler {
+	if l.buckets == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ok, wait := l.take(ClientIP(req), time.Now())
+		if !ok {
+			l.limited.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.Header().Set("Cache-Control", "no-store")
+			httpError(w, req, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
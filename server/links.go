@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// DeadLink is an episode whose enclosure no longer resolves
+type DeadLink struct {
+	UUID  string `json:"uuid"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// LinkChecker periodically checks every episode's MP3 is still there, so
+// broken enclosures show up in /healthz rather than going unnoticed
+type LinkChecker struct {
+	// Interval between checks
+	Interval time.Duration
+
+	// Concurrency is how many MP3s are checked at once, 4 if zero
+	Concurrency int
+
+	// Transport makes the requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+}
+
+// Run checks state's enclosures as soon as it has a feed, then every
+// Interval until ctx is done. A state checked within Interval already
+// (e.g. before the config was reloaded) waits out the rest of it
+func (c LinkChecker) Run(ctx context.Context, state *State) {
+	next := state.lastChecked().Add(c.Interval)
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if state.LastUpdated().IsZero() {
+			next = time.Now().Add(time.Minute)
+			continue
+		}
+		c.Check(state)
+		next = time.Now().Add(c.Interval)
+	}
+}
+
+// Check state's enclosures once, recording the dead ones
+func (c LinkChecker) Check(state *State) []DeadLink {
+	_, episodes, _ := state.Get()
+
+	n := c.Concurrency
+	if n < 1 {
+		n = 4
+	}
+	sem := make(chan struct{}, n)
+
+	// Checked concurrently but listed in the feed's order
+	found := make([]*DeadLink, len(episodes))
+	var wg sync.WaitGroup
+	for i, e := range episodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, e scraper.Episode) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := c.check(e.MP3); err != nil {
+				log.Printf("dead enclosure for %s: %s", e.Title, err)
+				found[i] = &DeadLink{UUID: e.UUID, Title: e.Title, URL: e.MP3, Error: err.Error()}
+			}
+		}(i, e)
+	}
+	wg.Wait()
+
+	var dead []DeadLink
+	for _, d := range found {
+		if d != nil {
+			dead = append(dead, *d)
+		}
+	}
+
+	state.mu.Lock()
+	state.dead = dead
+	state.checked = time.Now()
+	state.mu.Unlock()
+	return dead
+}
+
+// HEAD url, falling back to fetching its first byte for servers which
+// don't allow HEAD
+func (c LinkChecker) check(url string) error {
+	client := &http.Client{Transport: c.Transport, Timeout: 30 * time.Second}
+
+	res, err := client.Head(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		if res, err = client.Do(req); err != nil {
+			return err
+		}
+		res.Body.Close()
+	}
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strings"
+	"sync"
+)
+
+// LogBuffer keeps the last lines written to it, for showing recent log
+// output on the admin page. Use it as (part of) the log package's output
+type LogBuffer struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+// NewLogBuffer returns a LogBuffer keeping the last max lines
+func NewLogBuffer(max int) *LogBuffer {
+	return &LogBuffer{max: max}
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.max {
+		b.lines = append([]string(nil), b.lines[len(b.lines)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the lines kept, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Request headers passed on to KCRW, so Range requests and revalidation
+// work through the proxy
+var mediaRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// Response headers passed back to the client
+var mediaResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// Find the episode with the given UUID in any of the shows
+func findEpisode(shows []Show, uuid string) (Show, scraper.Episode, bool) {
+	for _, sh := range shows {
+		_, episodes, _ := sh.State.Get()
+		for _, e := range episodes {
+			if e.UUID == uuid {
+				return sh, e, true
+			}
+		}
+	}
+	return Show{}, scraper.Episode{}, false
+}
+
+// Stream episodes' MP3s from KCRW (or the mirror, if they're in it) at
+// /media/<uuid>.mp3, so enclosures can point at fanatic rather than URLs
+// KCRW might change. With redirect set MP3s that aren't mirrored are
+// left to KCRW
+func mediaHandler(shows []Show, cache CachePolicy, transport http.RoundTripper, mirror *Mirror, redirect bool) http.Handler {
+	client := &http.Client{Transport: transport}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			httpError(w, req, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(req.URL.Path, "/media/")
+		if !strings.HasSuffix(name, ".mp3") {
+			notFound(w, req)
+			return
+		}
+		_, e, ok := findEpisode(shows, strings.TrimSuffix(name, ".mp3"))
+		if !ok {
+			notFound(w, req)
+			return
+		}
+		if req.Method == "GET" {
+			episodesServed.Add(1)
+		}
+
+		if mirror != nil && mirror.serve(w, req, e.UUID, func() { cache.setHeaders(w, episodeKey(e)) }) {
+			return
+		}
+		if redirect {
+			http.Redirect(w, req, e.MP3, http.StatusFound)
+			return
+		}
+
+		up, err := http.NewRequestWithContext(req.Context(), req.Method, e.MP3, nil)
+		if err != nil {
+			reportRequest(req, err)
+			httpError(w, req, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, h := range mediaRequestHeaders {
+			if v := req.Header.Get(h); v != "" {
+				up.Header.Set(h, v)
+			}
+		}
+
+		res, err := client.Do(up)
+		if err != nil {
+			// The listener hung up, which isn't KCRW's fault
+			if req.Context().Err() != nil {
+				return
+			}
+			log.Printf("[%s] error fetching %s: %s", requestID(req.Context()), e.MP3, err)
+			reportRequest(req, err)
+			httpError(w, req, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode >= 500 {
+			log.Printf("[%s] error fetching %s: %s", requestID(req.Context()), e.MP3, res.Status)
+			reportRequest(req, fmt.Errorf("fetching %s: %s", e.MP3, res.Status))
+			httpError(w, req, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+
+		for _, h := range mediaResponseHeaders {
+			if v := res.Header.Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		// Only the MP3 (or part of it) is worth caching, not KCRW saying
+		// it's missing or refusing a range
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			cache.setHeaders(w, episodeKey(e))
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	})
+}
@@ -0,0 +1,409 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+var (
+	mirrored = expvar.NewInt("media_mirrored")
+	corrupt  = expvar.NewInt("media_mirror_corrupt")
+)
+
+// Mirror keeps a copy of every episode's MP3 in a MirrorStore, as
+// <uuid>.mp3, which /media/ serves instead of going to KCRW. Each has its
+// SHA-256 alongside in <uuid>.mp3.sha256, in the format sha256sum -c reads
+type Mirror struct {
+	Store MirrorStore
+
+	// VerifyInterval is how often to check saved MP3s against their
+	// checksums, downloading any that don't match again. Zero means never
+	VerifyInterval time.Duration
+
+	// Keep is how many of the newest episodes to keep, and KeepBytes how
+	// much space they may take up. Older episodes are deleted (and
+	// /media/ sends their requests to KCRW). Zero means no limit
+	Keep      int
+	KeepBytes int64
+
+	// Transport makes the downloads, http.DefaultTransport if nil
+	Transport http.RoundTripper
+
+	kick chan struct{}
+
+	// The size of every file in the store, nil until it's been listed
+	mu    sync.Mutex
+	saved map[string]int64
+}
+
+// NewMirror returns a Mirror saving MP3s to store
+func NewMirror(store MirrorStore) *Mirror {
+	return &Mirror{Store: store, kick: make(chan struct{}, 1)}
+}
+
+func mp3Name(uuid string) string {
+	return uuid + ".mp3"
+}
+
+// Load lists what's in the store, if that hasn't been done yet. It's
+// done by the first Sync otherwise
+func (m *Mirror) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.saved != nil {
+		return nil
+	}
+
+	saved, err := m.Store.List()
+	if err != nil {
+		return err
+	}
+	m.saved = saved
+	return nil
+}
+
+// The size of a stored file, if it's there
+func (m *Mirror) size(name string) (int64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.saved[name]
+	return n, ok
+}
+
+// Has reports whether the episode with the given UUID has been saved
+func (m *Mirror) Has(uuid string) bool {
+	_, ok := m.size(mp3Name(uuid))
+	return ok
+}
+
+// URL returns where the episode with the given UUID can be downloaded
+// straight from the store, if it's been saved and the store has public
+// URLs
+func (m *Mirror) URL(uuid string) string {
+	if !m.Has(uuid) {
+		return ""
+	}
+	return m.Store.URL(mp3Name(uuid))
+}
+
+// Kick asks Run to download anything new
+func (m *Mirror) Kick() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Run downloads the episodes of the states whenever kicked, until ctx is
+// done
+func (m *Mirror) Run(ctx context.Context, states []*State) {
+	var verify <-chan time.Time
+	if m.VerifyInterval > 0 {
+		ticker := time.NewTicker(m.VerifyInterval)
+		defer ticker.Stop()
+		verify = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-verify:
+			if m.Verify(ctx) > 0 {
+				m.Kick()
+			}
+			continue
+		case <-m.kick:
+		}
+
+		var episodes []scraper.Episode
+		for _, state := range states {
+			_, list, _ := state.Get()
+			episodes = append(episodes, list...)
+		}
+		m.Sync(ctx, episodes)
+	}
+}
+
+// Sync downloads any of the episodes not saved yet, newest first and one
+// at a time so KCRW isn't hammered, then deletes whatever the retention
+// limits leave out. Failures are logged and retried next time
+func (m *Mirror) Sync(ctx context.Context, episodes []scraper.Episode) {
+	if err := m.Load(); err != nil {
+		log.Printf("error listing mirror: %s", err)
+		return
+	}
+
+	keep := map[string]bool{}
+	var total int64
+	for _, e := range m.newest(episodes) {
+		if ctx.Err() != nil {
+			return
+		}
+		if m.Keep > 0 && len(keep) >= m.Keep {
+			break
+		}
+
+		size, ok := m.size(mp3Name(e.UUID))
+		if !ok {
+			size = e.Length
+		}
+		total += size
+		if m.KeepBytes > 0 && total > m.KeepBytes {
+			break
+		}
+		keep[mp3Name(e.UUID)] = true
+
+		if ok {
+			continue
+		}
+		if err := m.download(ctx, e); err != nil {
+			log.Printf("error mirroring %s: %s", e.MP3, err)
+			continue
+		}
+		mirrored.Add(1)
+	}
+
+	if m.Keep > 0 || m.KeepBytes > 0 {
+		m.prune(keep)
+	}
+}
+
+// The episodes without duplicates, newest first
+func (m *Mirror) newest(episodes []scraper.Episode) []scraper.Episode {
+	var unique []scraper.Episode
+	seen := map[string]bool{}
+	for _, e := range episodes {
+		if !seen[e.UUID] {
+			seen[e.UUID] = true
+			unique = append(unique, e)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return unique[i].PubDate.After(unique[j].PubDate)
+	})
+	return unique
+}
+
+// The names of the saved MP3s
+func (m *Mirror) mp3s() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.saved {
+		if strings.HasSuffix(name, ".mp3") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Delete every saved MP3 but those to keep
+func (m *Mirror) prune(keep map[string]bool) {
+	for _, name := range m.mp3s() {
+		if keep[name] {
+			continue
+		}
+		log.Printf("removing %s from the mirror", name)
+		m.remove(name)
+	}
+}
+
+// Delete a saved MP3 and its checksum
+func (m *Mirror) remove(name string) {
+	for _, n := range []string{name, name + ".sha256"} {
+		if err := m.Store.Delete(n); err != nil {
+			log.Printf("error removing %s: %s", n, err)
+		}
+		m.mu.Lock()
+		delete(m.saved, n)
+		m.mu.Unlock()
+	}
+}
+
+// Store a file, with its SHA-256 if it's known and the store keeps them,
+// remembering it's there
+func (m *Mirror) put(name string, r io.Reader, size int64, sum string) error {
+	var err error
+	if cs, ok := m.Store.(ChecksumStore); ok && sum != "" {
+		err = cs.PutSum(name, r, size, sum)
+	} else {
+		err = m.Store.Put(name, r, size)
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.saved[name] = size
+	m.mu.Unlock()
+	return nil
+}
+
+// Store the checksum of an MP3
+func (m *Mirror) putChecksum(name, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, name)
+	return m.put(name+".sha256", strings.NewReader(line), int64(len(line)), "")
+}
+
+// Hash a stored file, or have the store say what it is if it keeps
+// checksums
+func (m *Mirror) hash(name string) (string, error) {
+	if cs, ok := m.Store.(ChecksumStore); ok {
+		if sum, err := cs.Sum(name); err != nil || sum != "" {
+			return sum, err
+		}
+	}
+
+	f, err := m.Store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks every saved MP3 against its checksum, deleting those that
+// don't match so the next Sync downloads them again. MP3s saved without a
+// checksum get one. A ChecksumStore's own record of them is checked,
+// rather than reading them back. Returns how many were corrupt
+func (m *Mirror) Verify(ctx context.Context) int {
+	if err := m.Load(); err != nil {
+		log.Printf("error listing mirror: %s", err)
+		return 0
+	}
+
+	bad := 0
+	for _, name := range m.mp3s() {
+		if ctx.Err() != nil {
+			break
+		}
+
+		sum, err := m.hash(name)
+		if err != nil {
+			log.Printf("error verifying %s: %s", name, err)
+			continue
+		}
+
+		f, err := m.Store.Open(name + ".sha256")
+		if errors.Is(err, os.ErrNotExist) {
+			if err := m.putChecksum(name, sum); err != nil {
+				log.Printf("error writing checksum of %s: %s", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("error verifying %s: %s", name, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			log.Printf("error verifying %s: %s", name, err)
+			continue
+		}
+
+		fields := strings.Fields(string(b))
+		if len(fields) > 0 && fields[0] == sum {
+			continue
+		}
+		log.Printf("checksum mismatch for %s, downloading it again", name)
+		corrupt.Add(1)
+		bad++
+		m.remove(name)
+	}
+	return bad
+}
+
+// Download an episode's MP3 to a temporary file, which is stored once it's
+// complete
+func (m *Mirror) download(ctx context.Context, e scraper.Episode) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", e.MP3, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("mirroring %s", e.MP3)
+	client := &http.Client{Transport: m.Transport}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	tmp, err := ioutil.TempFile("", "fanatic-"+e.UUID+"-*.mp3")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), res.Body)
+	if err != nil {
+		return err
+	}
+	if res.ContentLength >= 0 && n != res.ContentLength {
+		return fmt.Errorf("got %d bytes, want %d", n, res.ContentLength)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	name, sum := mp3Name(e.UUID), hex.EncodeToString(h.Sum(nil))
+	if err := m.putChecksum(name, sum); err != nil {
+		return err
+	}
+	return m.put(name, tmp, n, sum)
+}
+
+// Serve a saved MP3, calling headers first to add any more, reporting
+// false if it isn't there. Stores with public URLs are redirected to
+func (m *Mirror) serve(w http.ResponseWriter, req *http.Request, uuid string, headers func()) bool {
+	if !m.Has(uuid) {
+		return false
+	}
+	if u := m.Store.URL(mp3Name(uuid)); u != "" {
+		http.Redirect(w, req, u, http.StatusFound)
+		return true
+	}
+
+	f, err := m.Store.Open(mp3Name(uuid))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+
+	headers()
+	w.Header().Set("Content-Type", "audio/mpeg")
+	http.ServeContent(w, req, "", time.Time{}, rs)
+	return true
+}
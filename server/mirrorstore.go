@@ -0,0 +1,102 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MirrorStore is where a Mirror keeps its files
+type MirrorStore interface {
+	// List returns the name and size of every file stored
+	List() (map[string]int64, error)
+
+	// Put stores size bytes read from r as name
+	Put(name string, r io.Reader, size int64) error
+
+	// Open a stored file for reading, failing with an error matching
+	// os.ErrNotExist if it isn't there
+	Open(name string) (io.ReadCloser, error)
+
+	// Delete a stored file. Deleting a file that isn't there is fine
+	Delete(name string) error
+
+	// URL returns where a stored file can be downloaded from, or "" for
+	// fanatic to serve it
+	URL(name string) string
+}
+
+// ChecksumStore is a MirrorStore which keeps its files' SHA-256s itself
+// (e.g. in object metadata), so a Mirror can verify them without reading
+// them back
+type ChecksumStore interface {
+	MirrorStore
+
+	// PutSum is Put, recording sum, the file's hex SHA-256, with it
+	PutSum(name string, r io.Reader, size int64, sum string) error
+
+	// Sum returns the SHA-256 recorded for a stored file, "" if it was
+	// stored without one
+	Sum(name string) (string, error)
+}
+
+// DirStore keeps a Mirror's files in a local directory
+type DirStore string
+
+func (d DirStore) List() (map[string]int64, error) {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]int64{}
+	for _, fi := range infos {
+		// skipping partial writes
+		if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+			files[fi.Name()] = fi.Size()
+		}
+	}
+	return files, nil
+}
+
+// Put writes a temporary file, renamed into place once it's complete
+func (d DirStore) Put(name string, r io.Reader, size int64) error {
+	tmp, err := ioutil.TempFile(string(d), "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(string(d), name))
+}
+
+func (d DirStore) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func (d DirStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d DirStore) URL(name string) string {
+	return ""
+}
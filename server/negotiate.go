@@ -0,0 +1,101 @@
+package server
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Formats builds a feed of the given episodes in some other format than
+// RSS, by its media type
+type Formats map[string]func([]scraper.Episode) (string, error)
+
+// Media types that mean RSS, which feeds are whatever's asked for
+var rssTypes = []string{"application/rss+xml", "application/xml", "text/xml"}
+
+// One media range of an Accept header
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		r := acceptRange{q: 1}
+		r.typ, r.subtype, _ = cut(mediaType, "/")
+		if q, ok := params["q"]; ok {
+			if r.q, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// Split s around the first sep
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// How much the client wants mediaType: the q of the most specific range
+// matching it, 0 if none does
+func quality(ranges []acceptRange, mediaType string) float64 {
+	typ, subtype, _ := cut(mediaType, "/")
+	q, specificity := 0.0, 0
+	for _, r := range ranges {
+		s := 0
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 3
+		case r.typ == typ && r.subtype == "*":
+			s = 2
+		case r.typ == "*" && r.subtype == "*":
+			s = 1
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// Pick the format the Accept header prefers out of those offered, "" for
+// RSS. RSS is the default, and wins ties and requests accepting nothing
+// there is
+func negotiate(accept string, formats Formats) string {
+	if accept == "" || len(formats) == 0 {
+		return ""
+	}
+	ranges := parseAccept(accept)
+	best := 0.0
+	for _, t := range rssTypes {
+		if q := quality(ranges, t); q > best {
+			best = q
+		}
+	}
+
+	var offered []string
+	for t := range formats {
+		offered = append(offered, t)
+	}
+	sort.Strings(offered)
+	picked := ""
+	for _, t := range offered {
+		if q := quality(ranges, t); q > best {
+			picked, best = t, q
+		}
+	}
+	return picked
+}
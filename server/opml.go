@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+type opml struct {
+	XMLName  xml.Name  `xml:"opml"`
+	Version  string    `xml:"version,attr"`
+	Title    string    `xml:"head>title"`
+	Created  string    `xml:"head>dateCreated"`
+	Outlines []outline `xml:"body>outline"`
+}
+
+type outline struct {
+	Type    string `xml:"type,attr"`
+	Text    string `xml:"text,attr"`
+	Title   string `xml:"title,attr"`
+	XMLURL  string `xml:"xmlUrl,attr"`
+	HTMLURL string `xml:"htmlUrl,attr,omitempty"`
+}
+
+// The title and link of a feed's channel
+func channelInfo(feed string) (title, link string) {
+	var rss struct {
+		Title string `xml:"channel>title"`
+		Links []struct {
+			XMLName xml.Name
+			Href    string `xml:",chardata"`
+		} `xml:"channel>link"`
+	}
+	xml.Unmarshal([]byte(feed), &rss)
+
+	// skip atom:links
+	for _, l := range rss.Links {
+		if l.XMLName.Space == "" {
+			return rss.Title, l.Href
+		}
+	}
+	return rss.Title, ""
+}
+
+// The scheme and host the request was made to, for absolute links
+func requestBase(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+// Serve an OPML subscription list of every show's feed, so they can all be
+// imported into a podcast app at once. Feeds are linked to at base, or the
+// host the request was made to if it's empty
+func opmlHandler(shows []Show, base string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		doc := opml{Version: "2.0", Title: "fanatic", Created: time.Now().UTC().Format(time.RFC1123)}
+		public := base
+		if public == "" {
+			public = requestBase(req)
+		}
+		for _, sh := range shows {
+			feed, _, _ := sh.State.Get()
+			title, link := channelInfo(feed)
+			if title == "" {
+				title = sh.Slug
+			}
+
+			doc.Outlines = append(doc.Outlines, outline{"rss", title, title, public + sh.Path(), link})
+		}
+
+		out, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			reportRequest(req, err)
+			httpError(w, req, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		w.Write(out)
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultPodpingURL is the public Podping HTTP gateway
+const DefaultPodpingURL = "https://podping.cloud/"
+
+// Podping announces feed updates on Podping, which Podcast Index aware
+// apps watch instead of polling. Tokens are handed out by Podcast Index
+type Podping struct {
+	// URL is the gateway, DefaultPodpingURL if empty
+	URL   string
+	Token string
+
+	// Feed is the public URL of the feed being announced
+	Feed string
+}
+
+// Publish announces that Feed has been updated
+func (p Podping) Publish() error {
+	gateway := p.URL
+	if gateway == "" {
+		gateway = DefaultPodpingURL
+	}
+
+	q := url.Values{"url": {p.Feed}, "reason": {"update"}, "medium": {"podcast"}}
+	req, err := http.NewRequest("GET", gateway+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", p.Token)
+	req.Header.Set("User-Agent", "fanatic")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("podping failed: %s", res.Status)
+	}
+
+	log.Printf("sent podping for %s", p.Feed)
+	return nil
+}
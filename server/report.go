@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrorReporter is told about failures worth more than a log line: failed
+// scrapes and requests the handler couldn't serve. tags describe where
+// the error happened, e.g. the show or the request path
+type ErrorReporter interface {
+	Report(ctx context.Context, err error, tags map[string]string)
+}
+
+// Report err to r, doing nothing if r is nil
+func report(r ErrorReporter, ctx context.Context, err error, tags map[string]string) {
+	if r != nil && err != nil {
+		r.Report(ctx, err, tags)
+	}
+}
+
+// Make the reporter available to handlers through their requests' context
+func withReporter(r ErrorReporter, next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), reporterKey, r)))
+	})
+}
+
+// Report an error serving req to the handler's reporter, if it has one
+func reportRequest(req *http.Request, err error) {
+	r, _ := req.Context().Value(reporterKey).(ErrorReporter)
+	report(r, req.Context(), err, map[string]string{
+		"method":     req.Method,
+		"path":       req.URL.Path,
+		"request_id": requestID(req.Context()),
+		"client_ip":  ClientIP(req),
+	})
+}
+
+// Sentry reports errors to Sentry (or anything speaking its store API,
+// like GlitchTip), as configured by a DSN
+type Sentry struct {
+	// The store endpoint and the key to send to it, from the DSN
+	Endpoint string
+	Key      string
+
+	// Environment and Release are attached to every event, if set
+	Environment string
+	Release     string
+
+	// Client sends events, one with a short timeout if nil
+	Client *http.Client
+}
+
+// NewSentry configures a Sentry reporter from a DSN, e.g.
+// https://<key>@o0.ingest.sentry.io/<project>
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || u.Host == "" || project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN %q", dsn)
+	}
+
+	// Any path before the project ID is kept in front of /api/
+	prefix, id := "", project
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, id = "/"+project[:i], project[i+1:]
+	}
+	endpoint := u.Scheme + "://" + u.Host + prefix + "/api/" + id + "/store/"
+	return &Sentry{Endpoint: endpoint, Key: u.User.Username()}, nil
+}
+
+// A Sentry event, just the parts fanatic fills in
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Report sends err to Sentry in the background, logging if that fails
+func (s *Sentry) Report(ctx context.Context, err error, tags map[string]string) {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	e := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "fanatic",
+		Environment: s.Environment,
+		Release:     s.Release,
+		Tags:        tags,
+	}
+	e.ServerName, _ = os.Hostname()
+	e.Exception.Values = []sentryException{{Type: fmt.Sprintf("%T", err), Value: err.Error()}}
+
+	go func() {
+		if err := s.send(e); err != nil {
+			log.Printf("error reporting to Sentry: %s", err)
+		}
+	}()
+}
+
+func (s *Sentry) send(e sentryEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=fanatic, sentry_key=%s", s.Key))
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("sentry: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule decides when the next refresh should happen
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// IntervalSchedule refreshes every Interval, delayed by a random amount up
+// to Jitter so a fleet of instances doesn't hit KCRW in lockstep
+type IntervalSchedule struct {
+	Interval time.Duration
+	Jitter   time.Duration
+}
+
+func (s IntervalSchedule) Next(t time.Time) time.Time {
+	next := t.Add(s.Interval)
+	if s.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+	}
+	return next
+}
+
+// CronSchedule refreshes on one or more cron specs, whichever fires first,
+// e.g. every 15 minutes on Sunday evenings and hourly otherwise
+type CronSchedule []cron.Schedule
+
+// ParseCron parses ";"-separated standard 5-field cron specs. Each may be
+// prefixed with CRON_TZ=<zone> to be evaluated in that timezone rather
+// than local time
+func ParseCron(spec string) (CronSchedule, error) {
+	var s CronSchedule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sched, err := cron.ParseStandard(part)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", part, err)
+		}
+		s = append(s, sched)
+	}
+	if len(s) == 0 {
+		return nil, errors.New("empty cron spec")
+	}
+	return s, nil
+}
+
+func (s CronSchedule) Next(t time.Time) time.Time {
+	var next time.Time
+	for _, sched := range s {
+		n := sched.Next(t)
+		if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// RefreshLoop regenerates the feed whenever the schedule says so, purging
+// the CDN when it changes, until ctx is cancelled
+func RefreshLoop(ctx context.Context, sched Schedule, state *State, cache CachePolicy) {
+	for {
+		next := sched.Next(time.Now())
+		state.setNext(next)
+		if next.IsZero() {
+			log.Println("refresh schedule has no future runs, no longer refreshing")
+			return
+		}
+		log.Printf("next refresh at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			state.setNext(time.Time{})
+			return
+		case <-timer.C:
+		}
+
+		changed, err := state.Refresh()
+		if err != nil {
+			log.Printf("error generating XML: %s", err)
+		}
+		if changed {
+			if err := cache.Purge("feed"); err != nil {
+				log.Printf("error purging CDN cache: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	transfersStarted = expvar.NewInt("media_transfers_started")
+	transfersAborted = expvar.NewInt("media_transfers_aborted")
+	transfersStalled = expvar.NewInt("media_transfers_stalled")
+)
+
+// ConnContext stashes the underlying connection in each request's context
+// so handlers can put deadlines on it. Set it as http.Server's ConnContext
+// for the slow client protection on downloads to take effect
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey, c)
+}
+
+// Writes to a client with a deadline on every write. A client that stops
+// reading for longer than idle, or is still downloading at the overall
+// deadline, gets its connection timed out instead of holding a goroutine
+// (and whatever the handler has open) forever. Either may be zero for no
+// limit
+type deadlineWriter struct {
+	http.ResponseWriter
+	conn     net.Conn
+	idle     time.Duration
+	deadline time.Time
+	failed   bool
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	d := w.deadline
+	if w.idle > 0 {
+		if idle := time.Now().Add(w.idle); d.IsZero() || idle.Before(d) {
+			d = idle
+		}
+	}
+	w.conn.SetWriteDeadline(d)
+
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil && !w.failed {
+		w.failed = true
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			transfersStalled.Add(1)
+		}
+	}
+	return n, err
+}
+
+func (w *deadlineWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Protect a handler serving large responses from slow or stalled clients
+func withWriteDeadline(idle, total time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, ok := req.Context().Value(connKey).(net.Conn)
+		// HTTP/2 multiplexes streams over one connection, so a deadline
+		// would hit every stream on it
+		if !ok || req.ProtoMajor != 1 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		dw := &deadlineWriter{ResponseWriter: w, conn: conn, idle: idle}
+		if total > 0 {
+			dw.deadline = time.Now().Add(total)
+		}
+
+		transfersStarted.Add(1)
+		next.ServeHTTP(dw, req)
+		if dw.failed {
+			transfersAborted.Add(1)
+			log.Printf("[%s] aborted transfer of %s", requestID(req.Context()), req.URL.Path)
+			return
+		}
+
+		// keep-alive connections are reused, so lift the deadline again
+		conn.SetWriteDeadline(time.Time{})
+	})
+}
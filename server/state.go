@@ -0,0 +1,332 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Generator produces the feed XML along with the episodes in it
+type Generator func() (string, []scraper.Episode, error)
+
+// Renderer builds the feed XML again from the episodes a Generator gave,
+// along with the episodes in it
+type Renderer func([]scraper.Episode) (string, []scraper.Episode, error)
+
+// State is the most recently generated feed, shared between the refresh
+// loop and the HTTP handlers
+type State struct {
+	// Monitor watches refreshes for failures. Set it before the first
+	// Refresh
+	Monitor Monitor
+
+	// OnNew is called with episodes a refresh finds that weren't in the
+	// feed before. The first feed's episodes don't count as new. May be
+	// nil
+	OnNew func([]scraper.Episode)
+
+	// OnChange is called after a refresh (or rebuild) changes the feed.
+	// May be nil
+	OnChange func()
+
+	// OnRefresh is called after every successful refresh (or rebuild),
+	// changed or not. May be nil
+	OnRefresh func()
+
+	// Render rebuilds the feed from the episodes it has, for Rebuild. May
+	// be nil
+	Render Renderer
+
+	// Same reports whether two feeds have the same content, apart from
+	// things like when they were built. Feeds are compared exactly if nil
+	Same func(a, b string) bool
+
+	generate Generator
+
+	mu       sync.RWMutex
+	xml      string
+	episodes []scraper.Episode
+	updated  time.Time
+	changed  time.Time
+	err      error
+
+	// Refreshes in a row that failed, and found no episodes
+	failures int
+	empty    int
+
+	// UUIDs of every episode seen so far, nil until there's been a feed
+	seen map[string]bool
+
+	// Episodes whose enclosures a LinkChecker found broken, and when it
+	// last looked
+	dead    []DeadLink
+	checked time.Time
+
+	// When RefreshLoop will next refresh, zero if it isn't running
+	next time.Time
+
+	// Turned off from the admin page: not refreshed or served
+	disabled bool
+
+	// The feed in other formats (e.g. Atom) by media type, as built since
+	// its content last changed, and how many times it has
+	formats  map[string]string
+	versions int
+
+	// The refresh in progress, if any, which others wait for rather than
+	// scraping again
+	flight singleflight.Group
+}
+
+// NewState returns an empty State which is filled by calling Refresh
+func NewState(generate Generator) *State {
+	return &State{generate: generate}
+}
+
+// Refresh regenerates the feed, reporting whether its content changed.
+// Disabled feeds are left as they are. Calls made while a refresh is
+// running wait for it and share its result instead of starting another
+func (s *State) Refresh() (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+
+	changed, err, _ := s.flight.Do("refresh", func() (interface{}, error) {
+		return s.refresh()
+	})
+	return changed.(bool), err
+}
+
+func (s *State) refresh() (bool, error) {
+	xml, episodes, err := s.generate()
+	refreshes.Add(1)
+	if err != nil {
+		refreshFailures.Add(1)
+	}
+
+	s.mu.Lock()
+	alert := s.record(err)
+	changed := false
+	var fresh []scraper.Episode
+	if err == nil {
+		// A feed that's only been rebuilt is kept as it was, so it still
+		// says it was built when its content last changed
+		if s.Same != nil && s.xml != "" && s.Same(xml, s.xml) {
+			xml = s.xml
+		}
+		changed = xml != s.xml
+		fresh = s.see(episodes)
+		s.xml = xml
+		s.episodes = episodes
+		s.updated = time.Now()
+		if changed {
+			s.changed = s.updated
+			s.clearFormats()
+		}
+	}
+	health := s.health()
+	s.mu.Unlock()
+
+	if alert && s.Monitor.Alert != nil {
+		s.Monitor.Alert(health)
+	}
+	if len(fresh) > 0 && s.OnNew != nil {
+		s.OnNew(fresh)
+	}
+	if changed && s.OnChange != nil {
+		s.OnChange()
+	}
+	if err == nil && s.OnRefresh != nil {
+		s.OnRefresh()
+	}
+	return changed, err
+}
+
+// Rebuild renders the feed again from the episodes it has, without
+// generating them again, when something that goes into it has changed
+// (e.g. a transcript's been found), reporting whether its content
+// changed. Episodes Render adds (e.g. backfilled ones) aren't new to
+// OnNew. Feeds without a Render are refreshed instead, as are those
+// without a feed yet, waiting for the refresh making one if there is
+func (s *State) Rebuild() (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	if s.Render == nil {
+		return s.Refresh()
+	}
+	if xml, _, _ := s.Get(); xml == "" {
+		if _, err := s.Refresh(); err != nil {
+			return false, err
+		}
+	}
+
+	var changed bool
+	for {
+		s.mu.RLock()
+		episodes, updated := s.episodes, s.updated
+		s.mu.RUnlock()
+
+		xml, episodes, err := s.Render(episodes)
+		if err != nil {
+			return false, err
+		}
+
+		s.mu.Lock()
+		// A refresh in the meantime rendered episodes this didn't have
+		if !s.updated.Equal(updated) {
+			s.mu.Unlock()
+			continue
+		}
+		if s.Same != nil && s.Same(xml, s.xml) {
+			xml = s.xml
+		}
+		changed = xml != s.xml
+		s.see(episodes)
+		s.xml = xml
+		s.episodes = episodes
+		if changed {
+			s.changed = time.Now()
+			s.clearFormats()
+		}
+		s.mu.Unlock()
+		break
+	}
+
+	if changed && s.OnChange != nil {
+		s.OnChange()
+	}
+	if s.OnRefresh != nil {
+		s.OnRefresh()
+	}
+	return changed, nil
+}
+
+// Set replaces the feed with one generated elsewhere (e.g. loaded from a
+// cache)
+func (s *State) Set(xml string, episodes []scraper.Episode, updated time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.xml = xml
+	s.episodes = episodes
+	s.updated = updated
+	s.changed = updated
+	s.err = nil
+	s.see(episodes)
+	s.clearFormats()
+}
+
+// Forget the feed's other formats, which are out of date. Called with
+// s.mu held
+func (s *State) clearFormats() {
+	s.formats = nil
+	s.versions++
+}
+
+// Formatted returns the feed in another format than RSS, building it from
+// the feed's episodes with build only the first time it's asked for after
+// the feed's content changes
+func (s *State) Formatted(format string, build func([]scraper.Episode) (string, error)) (string, error) {
+	s.mu.RLock()
+	body, ok := s.formats[format]
+	episodes, version := s.episodes, s.versions
+	s.mu.RUnlock()
+	if ok {
+		return body, nil
+	}
+
+	body, err := build(episodes)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	// Unless the feed's changed while it was being built
+	if s.versions == version {
+		if s.formats == nil {
+			s.formats = map[string]string{}
+		}
+		s.formats[format] = body
+	}
+	s.mu.Unlock()
+	return body, nil
+}
+
+// Remember episodes, returning those not seen before. Called with s.mu
+// held
+func (s *State) see(episodes []scraper.Episode) []scraper.Episode {
+	first := s.seen == nil
+	if first {
+		s.seen = map[string]bool{}
+	}
+
+	var fresh []scraper.Episode
+	for _, e := range episodes {
+		if !s.seen[e.UUID] {
+			s.seen[e.UUID] = true
+			fresh = append(fresh, e)
+		}
+	}
+	if first {
+		return nil
+	}
+	return fresh
+}
+
+// Get returns the current feed and its episodes, or the error from the
+// last refresh
+func (s *State) Get() (string, []scraper.Episode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.xml, s.episodes, s.err
+}
+
+// LastUpdated returns when the feed was last successfully generated
+func (s *State) LastUpdated() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updated
+}
+
+// LastChanged returns when the feed's content last changed
+func (s *State) LastChanged() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.changed
+}
+
+func (s *State) lastChecked() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checked
+}
+
+// NextRefresh returns when the feed is next due to be refreshed, zero if
+// it isn't on a schedule
+func (s *State) NextRefresh() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.next
+}
+
+func (s *State) setNext(t time.Time) {
+	s.mu.Lock()
+	s.next = t
+	s.mu.Unlock()
+}
+
+// Enabled reports whether the feed is refreshed and served
+func (s *State) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.disabled
+}
+
+// SetEnabled turns the feed on or off
+func (s *State) SetEnabled(on bool) {
+	s.mu.Lock()
+	s.disabled = !on
+	s.mu.Unlock()
+}
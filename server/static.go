@@ -0,0 +1,76 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+//go:embed static
+var embedded embed.FS
+
+// Assets returns the files served at /static/: the stylesheet, favicon and
+// artwork built in, each replaced by the file of the same name in dir if
+// there is one. dir may be empty
+func Assets(dir string) fs.FS {
+	def, _ := fs.Sub(embedded, "static")
+	if dir == "" {
+		return def
+	}
+	return overlayFS{os.DirFS(dir), def}
+}
+
+// A file system taking files from top where it has them and base
+// otherwise
+type overlayFS struct {
+	top, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir lists the files in both
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(o.top, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		seen[e.Name()] = true
+	}
+
+	base, berr := fs.ReadDir(o.base, name)
+	if berr != nil && err != nil {
+		return nil, berr
+	}
+	for _, e := range base {
+		if !seen[e.Name()] {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Serve assets, without directory listings
+func staticHandler(assets fs.FS, cache CachePolicy) http.Handler {
+	files := http.StripPrefix("/static/", http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/") {
+			notFound(w, req)
+			return
+		}
+		cache.setHeaders(w, "static")
+		files.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Status is what /status reports: how each show's refreshes are going and
+// which build is running
+type Status struct {
+	Version string       `json:"version"`
+	Commit  string       `json:"commit,omitempty"`
+	Built   string       `json:"built,omitempty"`
+	Started time.Time    `json:"started"`
+	Shows   []ShowStatus `json:"shows"`
+}
+
+// ShowStatus is a show's health along with when it's next refreshed
+type ShowStatus struct {
+	Slug string `json:"slug"`
+	Health
+	NextRefresh *time.Time `json:"next_refresh,omitempty"`
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>fanatic status</title>
+    <link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+    <h1>fanatic status</h1>
+    <p>version {{.Version}}{{with .Commit}}, commit {{.}}{{end}}{{with .Built}}, built {{.}}{{end}}, running since {{.Started.Format "2006-01-02 15:04:05 MST"}}</p>
+    <table>
+        <tr><th>show</th><th>status</th><th>episodes</th><th>last success</th><th>next refresh</th><th>last error</th></tr>
+        {{- range .Shows}}
+        <tr>
+            <td>{{.Slug}}</td>
+            <td>{{.Status}}</td>
+            <td>{{.Episodes}}</td>
+            <td>{{if .LastSuccess.IsZero}}never{{else}}{{.LastSuccess.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+            <td>{{with .NextRefresh}}{{.Format "2006-01-02 15:04:05 MST"}}{{else}}not scheduled{{end}}</td>
+            <td>{{.LastError}}</td>
+        </tr>
+        {{- end}}
+    </table>
+</body>
+</html>
+`))
+
+// Serve every show's refresh status, as HTML to browsers and JSON
+// otherwise (or as asked for with ?format=html or json)
+func statusHandler(shows []Show, version, commit, built string, started time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := Status{Version: version, Commit: commit, Built: built, Started: started}
+		for _, sh := range shows {
+			s := ShowStatus{Slug: sh.Slug, Health: sh.State.Health()}
+			if next := sh.State.NextRefresh(); !next.IsZero() {
+				s.NextRefresh = &next
+			}
+			status.Shows = append(status.Shows, s)
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		format := req.URL.Query().Get("format")
+		if format == "html" || format == "" && strings.Contains(req.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			statusPage.Execute(w, status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
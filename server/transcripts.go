@@ -0,0 +1,447 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+var transcribed = expvar.NewInt("transcripts_found")
+
+// ErrNoTranscript is returned by a TranscriptProvider for an episode it
+// has no transcript of, or none yet
+var ErrNoTranscript = errors.New("no transcript")
+
+// TranscriptProvider finds episodes' transcripts
+type TranscriptProvider interface {
+	// Transcript returns the episode's transcript and its media type,
+	// e.g. text/vtt
+	Transcript(ctx context.Context, e scraper.Episode) ([]byte, string, error)
+}
+
+// The kinds of transcript podcast apps understand, by the extension
+// they're saved and served with, in order of preference
+var transcriptExts = []string{".vtt", ".srt", ".json", ".html", ".txt"}
+
+var transcriptTypes = map[string]string{
+	".vtt":  "text/vtt",
+	".srt":  "application/x-subrip",
+	".json": "application/json",
+	".html": "text/html",
+	".txt":  "text/plain",
+}
+
+// The extension transcripts of the given media type are saved with, ""
+// if apps wouldn't understand them
+func transcriptExt(mediaType string) string {
+	t, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return ""
+	}
+	if t == "application/srt" {
+		t = "application/x-subrip"
+	}
+	for ext, et := range transcriptTypes {
+		if et == t {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Whether a UUID can name a file. Episodes imported without a GUID are
+// known by their MP3's URL, which can't
+func fileSafe(uuid string) bool {
+	return uuid != "" && !strings.ContainsAny(uuid, `/\`) && uuid != "." && uuid != ".."
+}
+
+// TranscriptDir finds transcripts saved in a directory as <uuid>.vtt,
+// .srt, .json, .html or .txt
+type TranscriptDir string
+
+func (d TranscriptDir) Transcript(ctx context.Context, e scraper.Episode) ([]byte, string, error) {
+	if !fileSafe(e.UUID) {
+		return nil, "", ErrNoTranscript
+	}
+	for _, ext := range transcriptExts {
+		data, err := ioutil.ReadFile(filepath.Join(string(d), e.UUID+ext))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return data, transcriptTypes[ext], nil
+	}
+	return nil, "", ErrNoTranscript
+}
+
+// TranscriptURL fetches transcripts from elsewhere: Pattern is their URL
+// with {uuid} and {number} standing in for the episode's
+type TranscriptURL struct {
+	Pattern   string
+	Transport http.RoundTripper
+}
+
+func (t TranscriptURL) Transcript(ctx context.Context, e scraper.Episode) ([]byte, string, error) {
+	if e.Number == 0 && strings.Contains(t.Pattern, "{number}") {
+		return nil, "", ErrNoTranscript
+	}
+	u := strings.NewReplacer("{uuid}", e.UUID, "{number}", strconv.Itoa(e.Number)).Replace(t.Pattern)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := (&http.Client{Transport: t.Transport, Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+		return nil, "", ErrNoTranscript
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching %s: %s", u, res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Servers often don't know what to call transcripts (.vtt files sent
+	// as text/plain, say), so what the URL says goes when it says anything
+	if mediaType, ok := transcriptTypes[path.Ext(req.URL.Path)]; ok {
+		return data, mediaType, nil
+	}
+	return data, res.Header.Get("Content-Type"), nil
+}
+
+// TranscriptService asks a speech recognition service to transcribe
+// episodes. Each is POSTed to URL as JSON with its uuid, title, mp3 and
+// duration (in seconds), with Token (if any) as a bearer token. The
+// service answers 200 with the transcript when it has one, and 202 while
+// it's still working on it
+type TranscriptService struct {
+	URL       string
+	Token     string
+	Transport http.RoundTripper
+}
+
+func (s TranscriptService) Transcript(ctx context.Context, e scraper.Episode) ([]byte, string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"uuid":     e.UUID,
+		"title":    e.Title,
+		"mp3":      e.MP3,
+		"duration": int64(e.Duration.Seconds()),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	res, err := (&http.Client{Transport: s.Transport, Timeout: 5 * time.Minute}).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted, http.StatusNotFound:
+		return nil, "", ErrNoTranscript
+	default:
+		return nil, "", fmt.Errorf("transcript service: %s", res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := res.Header.Get("Content-Type")
+	if transcriptExt(mediaType) == "" {
+		mediaType = sniffTranscript(data)
+	}
+	return data, mediaType, nil
+}
+
+// The media type of a transcript sent without a known one, going by what
+// it starts with. WebVTT files have to start with "WEBVTT", and SRT ones
+// with a cue number
+func sniffTranscript(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	switch {
+	case bytes.HasPrefix(data, []byte("WEBVTT")):
+		return "text/vtt"
+	case json.Valid(data):
+		return "application/json"
+	case srtRE.Match(data):
+		return "application/x-subrip"
+	}
+	return "text/plain"
+}
+
+var srtRE = regexp.MustCompile(`^\s*\d+\r?\n\d\d:\d\d:\d\d,\d\d\d --> `)
+
+// Transcripts keeps the transcripts a TranscriptProvider finds in Dir
+// (or in memory if it's empty) as <uuid>.<ext>, which /transcripts/
+// serves
+type Transcripts struct {
+	Provider TranscriptProvider
+	Dir      string
+
+	// Retry is how long to leave an episode without a transcript before
+	// asking about it again, doubling each time up to a week. Zero means
+	// asking every time
+	Retry time.Duration
+
+	kick chan struct{}
+
+	mu     sync.Mutex
+	saved  map[string]string // UUID to file name
+	memory map[string][]byte // file name to transcript, without a Dir
+	retry  map[string]*transcriptRetry
+}
+
+// When to ask the provider about an episode again
+type transcriptRetry struct {
+	at   time.Time
+	wait time.Duration
+}
+
+// NewTranscripts returns Transcripts found by provider, kept in dir
+func NewTranscripts(provider TranscriptProvider, dir string) *Transcripts {
+	return &Transcripts{
+		Provider: provider,
+		Dir:      dir,
+		kick:     make(chan struct{}, 1),
+		saved:    map[string]string{},
+		memory:   map[string][]byte{},
+		retry:    map[string]*transcriptRetry{},
+	}
+}
+
+// Load lists the transcripts already in Dir
+func (t *Transcripts) Load() error {
+	if t.Dir == "" {
+		return nil
+	}
+	files, err := ioutil.ReadDir(t.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if _, ok := transcriptTypes[ext]; ok && !f.IsDir() {
+			t.saved[strings.TrimSuffix(f.Name(), ext)] = f.Name()
+		}
+	}
+	return nil
+}
+
+// Find returns the file name the episode with the given UUID's transcript
+// is served as under /transcripts/ and its media type, or "" if it
+// doesn't have one
+func (t *Transcripts) Find(uuid string) (string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name := t.saved[uuid]
+	if name == "" {
+		return "", ""
+	}
+	return name, transcriptTypes[filepath.Ext(name)]
+}
+
+// The transcript saved as name
+func (t *Transcripts) read(name string) ([]byte, error) {
+	if t.Dir == "" {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if data, ok := t.memory[name]; ok {
+			return data, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return ioutil.ReadFile(filepath.Join(t.Dir, name))
+}
+
+func (t *Transcripts) save(uuid, name string, data []byte) error {
+	if t.Dir != "" {
+		if err := os.MkdirAll(t.Dir, 0755); err != nil {
+			return err
+		}
+		tmp := filepath.Join(t.Dir, "."+name+".tmp")
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(t.Dir, name)); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Dir == "" {
+		t.memory[name] = data
+	}
+	t.saved[uuid] = name
+	delete(t.retry, uuid)
+	return nil
+}
+
+// Whether it's time to ask about the episode with the given UUID
+func (t *Transcripts) due(uuid string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.saved[uuid] != "" {
+		return false
+	}
+	r := t.retry[uuid]
+	return r == nil || !now.Before(r.at)
+}
+
+// Put off asking about the episode with the given UUID again
+func (t *Transcripts) backOff(uuid string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Retry <= 0 {
+		return
+	}
+	r := t.retry[uuid]
+	if r == nil {
+		r = &transcriptRetry{wait: t.Retry}
+		t.retry[uuid] = r
+	} else if r.wait *= 2; r.wait > 7*24*time.Hour {
+		r.wait = 7 * 24 * time.Hour
+	}
+	r.at = now.Add(r.wait)
+}
+
+// Kick asks Run to look for new transcripts
+func (t *Transcripts) Kick() {
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Run looks for transcripts of the states' episodes whenever kicked,
+// rebuilding the states' feeds when it finds any so they link to them,
+// until ctx is done
+func (t *Transcripts) Run(ctx context.Context, states []*State) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.kick:
+		}
+
+		var episodes []scraper.Episode
+		for _, state := range states {
+			_, list, _ := state.Get()
+			episodes = append(episodes, list...)
+		}
+		if t.Sync(ctx, episodes) > 0 {
+			for _, state := range states {
+				if _, err := state.Rebuild(); err != nil {
+					log.Printf("error rebuilding feed with transcripts: %s", err)
+				}
+			}
+		}
+	}
+}
+
+// Sync asks the provider for the transcripts of the episodes that don't
+// have one yet, as they come due, returning how many it found
+func (t *Transcripts) Sync(ctx context.Context, episodes []scraper.Episode) int {
+	found := 0
+	for _, e := range episodes {
+		if ctx.Err() != nil {
+			break
+		}
+		now := time.Now()
+		if !fileSafe(e.UUID) || !t.due(e.UUID, now) {
+			continue
+		}
+
+		data, mediaType, err := t.Provider.Transcript(ctx, e)
+		ext := transcriptExt(mediaType)
+		if err == nil && ext == "" {
+			err = fmt.Errorf("unknown kind of transcript %q", mediaType)
+		}
+		if err != nil {
+			if err != ErrNoTranscript {
+				log.Printf("error finding transcript of %s: %s", e.UUID, err)
+			}
+			t.backOff(e.UUID, now)
+			continue
+		}
+
+		if err := t.save(e.UUID, e.UUID+ext, data); err != nil {
+			log.Printf("error saving transcript of %s: %s", e.UUID, err)
+			t.backOff(e.UUID, now)
+			continue
+		}
+		transcribed.Add(1)
+		found++
+	}
+	return found
+}
+
+// Serve transcripts at /transcripts/<uuid>.<ext>
+func transcriptHandler(t *Transcripts, cache CachePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/transcripts/")
+		ext := filepath.Ext(name)
+		uuid := strings.TrimSuffix(name, ext)
+		if t == nil || !fileSafe(uuid) {
+			notFound(w, req)
+			return
+		}
+		if saved, _ := t.Find(uuid); saved != name {
+			notFound(w, req)
+			return
+		}
+		data, err := t.read(name)
+		if err != nil {
+			notFound(w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", transcriptTypes[ext]+"; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		// Transcripts come from elsewhere, so HTML ones mustn't run scripts
+		// as this site, nor anything be taken for HTML
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		cache.setHeaders(w, "transcript-"+uuid)
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"expvar"
+	"runtime"
+)
+
+// Counters published at /debug/vars, alongside the limiters', media
+// transfers' and mirror's
+var (
+	refreshes       = expvar.NewInt("refreshes")
+	refreshFailures = expvar.NewInt("refresh_failures")
+	feedsServed     = expvar.NewInt("feeds_served")
+	episodesServed  = expvar.NewInt("episodes_served")
+)
+
+// The counter published as name, created if need be, so handlers can be
+// built more than once (e.g. when the config is reloaded)
+func counter(name string) *expvar.Int {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v
+	}
+	return expvar.NewInt(name)
+}
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebSub tells a WebSub (PubSubHubbub) hub when the feed at Topic changes,
+// so subscribers hear about new episodes without polling
+type WebSub struct {
+	Hub   string
+	Topic string
+}
+
+// Publish pings the hub to fetch Topic again
+func (w WebSub) Publish() error {
+	if w.Hub == "" {
+		return nil
+	}
+
+	form := url.Values{"hub.mode": {"publish"}, "hub.url": {w.Topic}}
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Post(w.Hub, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("websub publish failed: %s", res.Status)
+	}
+
+	log.Printf("pinged websub hub %s", w.Hub)
+	return nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/djl/fanatic/scraper"
+)
+
+// Serve a feed of the show's episodes from one year at <prefix><year>.xml,
+// for listening through a year of the show without it all being in the
+// main feed
+func yearHandler(sh Show, prefix string, cache CachePolicy, compact bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, prefix)
+		year, err := strconv.Atoi(strings.TrimSuffix(name, ".xml"))
+		if err != nil || !strings.HasSuffix(name, ".xml") || !sh.State.Enabled() {
+			notFound(w, req)
+			return
+		}
+
+		_, all, _ := sh.State.Get()
+		var episodes []scraper.Episode
+		for _, e := range all {
+			if e.PubDate.Year() == year {
+				episodes = append(episodes, e)
+			}
+		}
+		if len(episodes) == 0 {
+			notFound(w, req)
+			return
+		}
+
+		compact, err := xmlFormat(req, compact)
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		xml, err := sh.Render(episodes, compact)
+		if err != nil {
+			reportRequest(req, err)
+			httpError(w, req, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cache.setHeaders(w, feedKeys(episodes)...)
+		serveXML(w, req, xml, sh.State.LastChanged())
+	})
+}
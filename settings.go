@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/djl/fanatic/server"
+	"github.com/djl/fanatic/trace"
+)
+
+// Kinds of setting, for checking their values
+const (
+	textSetting = iota
+	intSetting
+	numberSetting
+	durationSetting
+)
+
+// Every setting read from the environment (or the config file's
+// settings), by name
+var knownSettings = map[string]int{
+	"ACME_CACHE_DIR":                     textSetting,
+	"ACME_DIRECTORY_URL":                 textSetting,
+	"ACME_EMAIL":                         textSetting,
+	"ACME_HOSTS":                         textSetting,
+	"ADMIN_TOKEN":                        textSetting,
+	"ALERT_COMMAND":                      textSetting,
+	"ALERT_THRESHOLD":                    intSetting,
+	"ALERT_WEBHOOK_URL":                  textSetting,
+	"ARCHIVE_ORG_QUERY":                  textSetting,
+	"AWS_ACCESS_KEY_ID":                  textSetting,
+	"AWS_SECRET_ACCESS_KEY":              textSetting,
+	"BASE_URL":                           textSetting,
+	"BROADCAST_LENGTH":                   durationSetting,
+	"BROADCAST_POLL_INTERVAL":            durationSetting,
+	"BROADCAST_TIME":                     textSetting,
+	"BROADCAST_TZ":                       textSetting,
+	"BROADCAST_WINDOW":                   durationSetting,
+	"CACHE_MAX_AGE":                      durationSetting,
+	"CDN_MAX_AGE":                        durationSetting,
+	"CDN_PURGE_TOKEN":                    textSetting,
+	"CDN_PURGE_URL":                      textSetting,
+	"CORS_ORIGINS":                       textSetting,
+	"FEED_GUID":                          textSetting,
+	"FEED_LIMIT":                         intSetting,
+	"FEED_ORDER":                         textSetting,
+	"FEED_PAGE_SIZE":                     intSetting,
+	"FEED_PATH":                          textSetting,
+	"FEED_URL":                           textSetting,
+	"FEED_XML":                           textSetting,
+	"HTTP_REDIRECT_PORT":                 textSetting,
+	"IDLE_TIMEOUT":                       durationSetting,
+	"KCRW_TZ":                            textSetting,
+	"KCRW_URL":                           textSetting,
+	"LINK_CHECK_CONCURRENCY":             intSetting,
+	"LINK_CHECK_INTERVAL":                durationSetting,
+	"LISTEN":                             textSetting,
+	"MAX_HEADER_BYTES":                   intSetting,
+	"MAX_REQUESTS":                       intSetting,
+	"MAX_STREAMS":                        intSetting,
+	"MEDIA_DEADLINE":                     durationSetting,
+	"MEDIA_IDLE_TIMEOUT":                 durationSetting,
+	"MEDIA_PROXY":                        textSetting,
+	"MIRROR_DIR":                         textSetting,
+	"MIRROR_KEEP":                        intSetting,
+	"MIRROR_KEEP_GB":                     numberSetting,
+	"MIRROR_PUBLIC_URL":                  textSetting,
+	"MIRROR_S3":                          textSetting,
+	"MIRROR_VERIFY_INTERVAL":             durationSetting,
+	"NO_PROXY":                           textSetting,
+	"NOTIFY_COMMAND":                     textSetting,
+	"NOTIFY_NTFY_SERVER":                 textSetting,
+	"NOTIFY_NTFY_TOKEN":                  textSetting,
+	"NOTIFY_NTFY_TOPIC":                  textSetting,
+	"NOTIFY_WEBHOOK_URL":                 textSetting,
+	"OTEL_EXPORTER_OTLP_ENDPOINT":        textSetting,
+	"OTEL_EXPORTER_OTLP_HEADERS":         textSetting,
+	"OTEL_EXPORTER_OTLP_PROTOCOL":        textSetting,
+	"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": textSetting,
+	"OTEL_EXPORTER_OTLP_TRACES_HEADERS":  textSetting,
+	"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": textSetting,
+	"OTEL_SDK_DISABLED":                  textSetting,
+	"OTEL_SERVICE_NAME":                  textSetting,
+	"OTEL_TRACES_EXPORTER":               textSetting,
+	"PODPING":                            textSetting,
+	"PODPING_TOKEN":                      textSetting,
+	"PODPING_URL":                        textSetting,
+	"PORT":                               textSetting,
+	"PUBLISH_DELAY":                      durationSetting,
+	"PUSHOVER_TOKEN":                     textSetting,
+	"PUSHOVER_USER":                      textSetting,
+	"RATE_BURST":                         intSetting,
+	"RATE_LIMIT":                         numberSetting,
+	"READ_HEADER_TIMEOUT":                durationSetting,
+	"READ_TIMEOUT":                       durationSetting,
+	"REFRESH_CRON":                       textSetting,
+	"REFRESH_INTERVAL":                   durationSetting,
+	"REFRESH_JITTER":                     durationSetting,
+	"RESOLVE_REDIRECTS":                  durationSetting,
+	"RETRY_AFTER":                        durationSetting,
+	"S3_ACL":                             textSetting,
+	"S3_BUCKET":                          textSetting,
+	"S3_ENDPOINT":                        textSetting,
+	"S3_PATH_STYLE":                      textSetting,
+	"S3_PREFIX":                          textSetting,
+	"S3_REGION":                          textSetting,
+	"SCRAPE_DELAY":                       durationSetting,
+	"SENTRY_DSN":                         textSetting,
+	"SENTRY_ENVIRONMENT":                 textSetting,
+	"SHUTDOWN_TIMEOUT":                   durationSetting,
+	"SMTP_ADDR":                          textSetting,
+	"SMTP_FROM":                          textSetting,
+	"SMTP_PASSWORD":                      textSetting,
+	"SMTP_TO":                            textSetting,
+	"SMTP_USERNAME":                      textSetting,
+	"STATE_DIR":                          textSetting,
+	"STATIC_DIR":                         textSetting,
+	"TLS_CERT":                           textSetting,
+	"TLS_KEY":                            textSetting,
+	"TRANSCRIPT_DIR":                     textSetting,
+	"TRANSCRIPT_SERVICE":                 textSetting,
+	"TRANSCRIPT_SERVICE_TOKEN":           textSetting,
+	"TRANSCRIPT_URL":                     textSetting,
+	"TRUSTED_PROXIES":                    textSetting,
+	"USER_AGENT":                         textSetting,
+	"WEBSUB_HUB":                         textSetting,
+	"WRITE_TIMEOUT":                      durationSetting,
+}
+
+// settings are the config file's values for settings otherwise taken
+// from the environment, e.g. {"REFRESH_INTERVAL": "30m", "MAX_STREAMS":
+// 16, "MEDIA_PROXY": true}
+type settings map[string]string
+
+// UnmarshalJSON reads strings, numbers and booleans (false being unset)
+// as the environment would have them
+func (s *settings) UnmarshalJSON(b []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*s = settings{}
+	for name, v := range raw {
+		if _, ok := knownSettings[name]; !ok {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		switch v := v.(type) {
+		case string:
+			(*s)[name] = v
+		case float64:
+			(*s)[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			if v {
+				(*s)[name] = "true"
+			}
+		default:
+			return fmt.Errorf("setting %s must be a string, number or boolean", name)
+		}
+	}
+	return nil
+}
+
+// Guards conf against reloads while settings are read
+var confMu sync.RWMutex
+
+// The setting called name, from the environment or else the config file.
+// Commands' flags, where there are any, take precedence over both
+func getenv(name string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	confMu.RLock()
+	defer confMu.RUnlock()
+	return conf.Settings[name]
+}
+
+// The setting called name if c were the config, from the environment or
+// else c's settings
+func (c config) getenv(name string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return c.Settings[name]
+}
+
+// Check every setting has a value of the right kind, wherever it's from
+func checkSettings() []error {
+	return checkSettingsWith(getenv)
+}
+
+// Check every setting get finds has a value of the right kind
+func checkSettingsWith(get func(string) string) []error {
+	var names []string
+	for name := range knownSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		v := get(name)
+		if v == "" {
+			continue
+		}
+		var err error
+		switch knownSettings[name] {
+		case intSetting:
+			_, err = strconv.Atoi(v)
+		case numberSetting:
+			_, err = strconv.ParseFloat(v, 64)
+		case durationSetting:
+			_, err = time.ParseDuration(v)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %s", name, strings.TrimPrefix(err.Error(), "time: ")))
+		}
+	}
+	return errs
+}
+
+// Where a setting's value comes from, "" if it isn't set
+func settingSource(name string) string {
+	if _, ok := os.LookupEnv(name); ok {
+		return "environment"
+	}
+	confMu.RLock()
+	defer confMu.RUnlock()
+	if _, ok := conf.Settings[name]; ok {
+		return "config file"
+	}
+	return ""
+}
+
+// Check the configuration, from the environment and the config file, for
+// mistakes, before serve (or anything else) trips over them
+func cmdConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf(`usage: fanatic config check [-v]`)
+	}
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "list the settings in effect and where each comes from")
+	flags.Parse(args[1:])
+
+	if *verbose {
+		var names []string
+		for name := range knownSettings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, name := range names {
+			source := settingSource(name)
+			if source == "" {
+				continue
+			}
+			v := getenv(name)
+			if secretRE.MatchString(name) {
+				v = "(hidden)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, v, source)
+		}
+		tw.Flush()
+	}
+
+	errs := checkSettings()
+	if len(errs) == 0 {
+		errs = checkConfig()
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+	fmt.Println("config ok")
+	return nil
+}
+
+// One error out of several problems found checking the configuration
+func joinErrors(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// Settings whose values config check doesn't print
+var secretRE = regexp.MustCompile(`TOKEN|PASSWORD|SECRET|DSN|HEADERS`)
+
+// Check what's built from the settings and the config file, once the
+// settings themselves are known to be good
+func checkConfig() []error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	_, err := configuredShows()
+	check(err)
+	_, err = scheduleFromEnv()
+	check(err)
+	_, err = landingTemplate()
+	check(err)
+	_, err = mirrorFromEnv()
+	check(err)
+	_, err = transcriptsFromEnv()
+	check(err)
+	_, err = trace.FromGetenv(getenv)
+	check(err)
+	if _, err := server.ParseTrustedProxies(getenv("TRUSTED_PROXIES")); err != nil {
+		check(fmt.Errorf("invalid TRUSTED_PROXIES: %s", err))
+	}
+	_, err = parseXMLFormat(getenv("FEED_XML"))
+	check(err)
+	_, err = parseFeedPath(getenv("FEED_PATH"))
+	check(err)
+	tlsCert, tlsKey, acmeHosts := getenv("TLS_CERT"), getenv("TLS_KEY"), getenv("ACME_HOSTS")
+	if (tlsCert == "") != (tlsKey == "") {
+		check(fmt.Errorf("TLS_CERT and TLS_KEY go together"))
+	}
+	if tlsCert != "" && acmeHosts != "" {
+		check(fmt.Errorf("TLS_CERT and ACME_HOSTS can't both be set"))
+	}
+	if listen := getenv("LISTEN"); listen != "" {
+		_, err = parseListen(listen, tlsCert != "" || acmeHosts != "")
+		check(err)
+	}
+
+	if auth := authFromEnv(); currentConf().Pprof && !auth.Enabled() {
+		check(fmt.Errorf("pprof needs auth set"))
+	}
+	return errs
+}
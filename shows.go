@@ -0,0 +1,1095 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/djl/fanatic/feed"
+	"github.com/djl/fanatic/fixture"
+	"github.com/djl/fanatic/scraper"
+	"github.com/djl/fanatic/server"
+	"github.com/djl/fanatic/trace"
+)
+
+// A KCRW show to scrape and serve, from the config file's shows
+type show struct {
+	// Slug names the show in URLs, /shows/<slug>/rss.xml
+	Slug string `json:"slug"`
+
+	// URL is the show page on kcrw.com
+	URL string `json:"url"`
+
+	// The feed's metadata, the Henry Rollins show's where empty
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Language    string `json:"language"`
+	Copyright   string `json:"copyright"`
+
+	// FeedURL is the public URL of the show's feed, which WebSub and
+	// Podping announcements need. FEED_URL for the default show
+	FeedURL string `json:"feed_url"`
+
+	// PageSize splits the feed into RFC 5005 archives of this many
+	// episodes once it has at least twice as many. FEED_PAGE_SIZE if zero,
+	// -1 for no archives
+	PageSize int `json:"page_size"`
+
+	// Limit caps how many episodes the feed has, FEED_LIMIT if zero, -1
+	// for no cap. Ignored when the feed is split into archives
+	Limit int `json:"limit"`
+
+	// Include and Exclude filter episodes by keywords in their title or
+	// description, ignoring case. With Include set only episodes matching
+	// one of its keywords are kept, and any matching one in Exclude are
+	// dropped
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+
+	// StripTitle lists prefixes, like "Henry Rollins - KCRW Broadcast", to
+	// cut from the start of episode titles. TitleTemplate then rewrites
+	// each title, e.g. {{.PubDate.Format "2006-01-02"}} — {{.Title}}, with
+	// the episode as its data
+	StripTitle    []string `json:"strip_title"`
+	TitleTemplate string   `json:"title_template"`
+
+	// Combine makes this a feed of the episodes of other shows, named by
+	// slug, instead of a show of its own. URL is optional and only used
+	// as the feed's link
+	Combine []string `json:"combine"`
+
+	// Backfill is an archive.org advanced search finding old episodes of
+	// the show, which fill in days KCRW no longer lists episodes for.
+	// ARCHIVE_ORG_QUERY for the default show
+	Backfill string `json:"backfill"`
+
+	// RefreshInterval, e.g. "15m", refreshes this show on its own
+	// interval rather than the schedule set in the environment
+	RefreshInterval string `json:"refresh_interval"`
+
+	// PublishDelay, e.g. "3h", holds episodes back from the feed until
+	// that long after they were broadcast, to match the schedule in
+	// another timezone. PUBLISH_DELAY if empty
+	PublishDelay string `json:"publish_delay"`
+
+	// GUID is what new episodes are known by in the feed: "uuid", KCRW's
+	// ID for them, or "url", their page's URL. FEED_GUID if empty.
+	// Episodes already in the feed keep the GUIDs they have
+	GUID string `json:"guid"`
+
+	// Order is "newest" to list episodes newest first or "oldest" for
+	// oldest first. FEED_ORDER if empty
+	Order string `json:"order"`
+
+	// Podcasting 2.0 tags. PodcastGUID is the feed's podcast:guid, worked
+	// out from FeedURL if empty. Locked, if set, asks other podcast hosts
+	// not to import the feed (or says they may), and LockedOwner is the
+	// email address to prove ownership with. Funding is where to support
+	// the show, and Persons who's on it, Henry Rollins as host if nil
+	PodcastGUID string         `json:"podcast_guid"`
+	Locked      *bool          `json:"locked"`
+	LockedOwner string         `json:"locked_owner"`
+	Funding     []feed.Funding `json:"funding"`
+	Persons     []feed.Person  `json:"persons"`
+
+	// The shows named by Combine
+	sources []show
+
+	// RefreshInterval and PublishDelay, parsed
+	interval time.Duration
+	delay    time.Duration
+
+	// Whether GUID is "url", and Order "oldest"
+	guidURL     bool
+	oldestFirst bool
+
+	// The config file's selectors, fallbacks and media preference, as of
+	// when the show was configured
+	selectors scraper.Selectors
+	fallbacks []scraper.Selectors
+	media     scraper.MediaPreference
+
+	// TitleTemplate, parsed
+	titleTemplate *template.Template
+
+	// KCRW_TZ, loaded
+	location *time.Location
+
+	// Where its scraping failures are reported, as of when the show was
+	// configured. May be nil
+	reporter server.ErrorReporter
+
+	// Whether the show's feeds are built compact, if not as -xml or
+	// FEED_XML say
+	compact *bool
+}
+
+// generate and publish's -xml, which wins over FEED_XML
+var xmlFormat string
+
+// What's known about episodes' MP3s, shared by every show and refresh
+var mediaCache = scraper.NewMediaCache()
+
+// Show pages as last scraped, for fetching them conditionally
+var pageCache = scraper.NewPageCache()
+
+// Spaces out every request made scraping, SCRAPE_DELAY apart once the
+// config is loaded
+var pacer = scraper.NewPacer(0)
+
+// Whether enclosures point at fanatic's /media/ proxy, which only serve
+// runs
+var proxyMedia bool
+
+var slugRE = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// The shows to serve, the default (see default_show) first. Without any
+// in the config file it's just Henry Rollins' show at KCRW_URL
+func configuredShows() ([]show, error) {
+	c := currentConf()
+	shows := c.Shows
+	if len(shows) == 0 {
+		shows = []show{{Slug: "henry-rollins", URL: kcrwURL()}}
+	}
+
+	seen := map[string]bool{}
+	def := -1
+	for i, sh := range shows {
+		if !slugRE.MatchString(sh.Slug) {
+			return nil, fmt.Errorf("show %d: invalid slug %q", i, sh.Slug)
+		}
+		if seen[sh.Slug] {
+			return nil, fmt.Errorf("show %q configured twice", sh.Slug)
+		}
+		seen[sh.Slug] = true
+		if sh.URL == "" && len(sh.Combine) == 0 {
+			return nil, fmt.Errorf("show %q has no url", sh.Slug)
+		}
+		if sh.Slug == c.DefaultShow {
+			def = i
+		}
+	}
+	if c.DefaultShow != "" && def < 0 {
+		return nil, fmt.Errorf("default show %q isn't configured", c.DefaultShow)
+	}
+	loc, err := kcrwLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	if def > 0 {
+		ordered := []show{shows[def]}
+		ordered = append(ordered, shows[:def]...)
+		shows = append(ordered, shows[def+1:]...)
+	} else {
+		shows = append([]show(nil), shows...)
+	}
+
+	for i, sh := range shows {
+		shows[i].selectors, shows[i].fallbacks, shows[i].media = c.Selectors, c.Fallbacks, c.Media
+		shows[i].location = loc
+		shows[i].reporter = reporter
+		if sh.RefreshInterval != "" {
+			d, err := time.ParseDuration(sh.RefreshInterval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("show %q: invalid refresh_interval %q", sh.Slug, sh.RefreshInterval)
+			}
+			shows[i].interval = d
+		}
+		if sh.PublishDelay != "" {
+			d, err := time.ParseDuration(sh.PublishDelay)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("show %q: invalid publish_delay %q", sh.Slug, sh.PublishDelay)
+			}
+			shows[i].delay = d
+		}
+		switch guid := sh.guidScheme(); guid {
+		case "uuid":
+		case "url":
+			shows[i].guidURL = true
+		default:
+			if sh.GUID == "" {
+				return nil, fmt.Errorf("invalid FEED_GUID %q: want uuid or url", guid)
+			}
+			return nil, fmt.Errorf("show %q: invalid guid %q, want uuid or url", sh.Slug, guid)
+		}
+		if err := sh.checkPodcastTags(); err != nil {
+			return nil, fmt.Errorf("show %q: %s", sh.Slug, err)
+		}
+		switch order := sh.order(); order {
+		case "newest":
+		case "oldest":
+			// Archives would still run newest first behind it
+			if sh.pageSize() > 0 {
+				return nil, fmt.Errorf("show %q: order oldest can't be used with archive pages (page_size or FEED_PAGE_SIZE)", sh.Slug)
+			}
+			shows[i].oldestFirst = true
+		default:
+			if sh.Order == "" {
+				return nil, fmt.Errorf("invalid FEED_ORDER %q: want newest or oldest", order)
+			}
+			return nil, fmt.Errorf("show %q: invalid order %q, want newest or oldest", sh.Slug, order)
+		}
+		if sh.TitleTemplate == "" {
+			continue
+		}
+		t, err := template.New(sh.Slug).Parse(sh.TitleTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("show %q: invalid title_template: %s", sh.Slug, err)
+		}
+		shows[i].titleTemplate = t
+	}
+
+	bySlug := map[string]show{}
+	for _, sh := range shows {
+		bySlug[sh.Slug] = sh
+	}
+	for i, sh := range shows {
+		for _, slug := range sh.Combine {
+			src, ok := bySlug[slug]
+			if !ok {
+				return nil, fmt.Errorf("show %q combines unknown show %q", sh.Slug, slug)
+			}
+			if len(src.Combine) > 0 {
+				return nil, fmt.Errorf("show %q combines %q, which is itself combined", sh.Slug, slug)
+			}
+			shows[i].sources = append(shows[i].sources, src)
+		}
+		if shows[i].URL == "" {
+			shows[i].URL = shows[i].sources[0].URL
+		}
+	}
+
+	if shows[0].FeedURL == "" {
+		shows[0].FeedURL = getenv("FEED_URL")
+	}
+	if err := checkBaseURL(); err != nil {
+		return nil, err
+	}
+	if base := baseURL(); base != "" {
+		feedPath, err := parseFeedPath(getenv("FEED_PATH"))
+		if err != nil {
+			return nil, err
+		}
+		for i, sh := range shows {
+			if sh.FeedURL != "" {
+				continue
+			}
+			shows[i].FeedURL = base + "/shows/" + sh.Slug + "/rss.xml"
+			if i == 0 {
+				shows[i].FeedURL = base + feedPath
+			}
+		}
+	}
+	if shows[0].Backfill == "" && len(shows[0].sources) == 0 {
+		shows[0].Backfill = getenv("ARCHIVE_ORG_QUERY")
+	}
+	if proxyMedia {
+		for _, sh := range shows {
+			if sh.base() == "" {
+				return nil, fmt.Errorf("MEDIA_PROXY needs the public URL of %q's feed (FEED_URL or feed_url) or base_url", sh.Slug)
+			}
+		}
+	}
+	if err := checkAnnounceEnv(shows[0].FeedURL); err != nil {
+		return nil, err
+	}
+	return shows, nil
+}
+
+// The show picked with -show, or the default
+func selectedShow() (show, error) {
+	shows, err := configuredShows()
+	if err != nil {
+		return show{}, err
+	}
+	if showSlug == "" {
+		return shows[0], nil
+	}
+	for _, sh := range shows {
+		if sh.Slug == showSlug {
+			return sh, nil
+		}
+	}
+	return show{}, fmt.Errorf("no show %q configured", showSlug)
+}
+
+func (sh show) scraper() *scraper.Scraper {
+	s := scraper.New(sh.URL)
+	s.Selectors = sh.selectors
+	s.Fallbacks = sh.fallbacks
+	s.Preference = sh.media
+	s.Location = sh.location
+	s.Media = mediaCache
+	s.ResolveRedirects = envDuration("RESOLVE_REDIRECTS", 0)
+	s.UserAgent = getenv("USER_AGENT")
+	s.Pacer = pacer
+	s.Pages = pageCache
+	if offline {
+		s.Transport = &fixture.Replayer{Dir: fixtures}
+	}
+	s.Transport = trace.Transport(s.Transport)
+	return s
+}
+
+// Make requests through next (http.DefaultTransport if nil) the way the
+// scraper does, as USER_AGENT, spaced out by the shared pacer and traced
+func politeTransport(next http.RoundTripper) http.RoundTripper {
+	return scraper.Polite(trace.Transport(next), getenv("USER_AGENT"), pacer)
+}
+
+// What fetches KCRW's MP3s goes through, as USER_AGENT like the scraper,
+// or to the recorded responses with -offline. Background jobs (the mirror
+// and link checker) are spaced out by the shared pacer too, but listeners'
+// downloads through the media proxy aren't, so they neither wait for the
+// scraper nor hold it up
+func kcrwTransport(paced bool) http.RoundTripper {
+	var next http.RoundTripper
+	if offline {
+		next = &fixture.Replayer{Dir: fixtures}
+	}
+	if paced {
+		return politeTransport(next)
+	}
+	return scraper.Polite(trace.Transport(next), getenv("USER_AGENT"), nil)
+}
+
+func (sh show) builder() *feed.FeedBuilder {
+	b := feed.New(sh.URL)
+	b.Generator = "fanatic " + buildInfo()
+	b.Compact = sh.compactXML()
+	b.OldestFirst = sh.oldestFirst
+	b.TTL = sh.refreshInterval()
+	if sh.Title != "" {
+		b.Title = sh.Title
+	}
+	if sh.Description != "" {
+		b.Description = sh.Description
+	}
+	if sh.Language != "" {
+		b.Language = sh.Language
+	}
+	if sh.Copyright != "" {
+		b.Copyright = sh.Copyright
+	}
+
+	ws := webSub(sh.FeedURL)
+	b.Self, b.Hub = ws.Topic, ws.Hub
+
+	b.GUID, b.LockedOwner, b.Funding = sh.PodcastGUID, sh.LockedOwner, sh.Funding
+	if sh.Locked != nil {
+		b.Locked = "no"
+		if *sh.Locked {
+			b.Locked = "yes"
+		}
+	}
+	if sh.Persons != nil {
+		b.Persons = sh.Persons
+	}
+
+	// Artwork needs an absolute URL
+	if base := sh.base(); base != "" {
+		b.Image = base + "/static/artwork.png"
+	}
+	if transcripts != nil {
+		base := sh.base()
+		b.Transcript = func(e scraper.Episode) (string, string) {
+			name, mediaType := transcripts.Find(e.UUID)
+			if name == "" {
+				return "", ""
+			}
+			return base + "/transcripts/" + name, mediaType
+		}
+	}
+	if proxyMedia || mirror != nil {
+		base := sh.base()
+		b.Enclosure = func(e scraper.Episode) string {
+			if mirror != nil {
+				if u := mirror.URL(e.UUID); u != "" {
+					return u
+				}
+			}
+			if proxyMedia {
+				return base + "/media/" + e.UUID + ".mp3"
+			}
+			return e.MP3
+		}
+	}
+	return b
+}
+
+// Scrape the show (or the shows it combines) and build its feed
+func (sh show) generate() (string, []scraper.Episode, error) {
+	var episodes []scraper.Episode
+	if len(sh.sources) == 0 {
+		var err error
+		if episodes, err = sh.scraper().Episodes(); err != nil {
+			return "", nil, err
+		}
+	} else {
+		var lists [][]scraper.Episode
+		for _, src := range sh.sources {
+			list, err := src.scraper().Episodes()
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: %s", src.Slug, err)
+			}
+			if list, err = src.prepare(list); err != nil {
+				return "", nil, fmt.Errorf("%s: %s", src.Slug, err)
+			}
+			lists = append(lists, list)
+		}
+		episodes = sh.combine(lists)
+	}
+	return sh.build(episodes)
+}
+
+// Scrape the show and build its feed from what's found along with the
+// episodes it had before, so those KCRW no longer lists stay in it, and
+// any backfilled episodes (as found by the time it's scraped) from days it
+// has none for
+func (sh show) generateKeeping(ctx context.Context, before []scraper.Episode, backfill func() []scraper.Episode) (string, []scraper.Episode, error) {
+	sctx, span := trace.Start(ctx, "scrape")
+	episodes, err := sh.scraper().EpisodesContext(sctx)
+	span.SetAttr("episodes", len(episodes))
+	span.End(err)
+	if err != nil {
+		return "", nil, err
+	}
+	if episodes, err = sh.prepare(episodes); err != nil {
+		return "", nil, err
+	}
+
+	_, span = trace.Start(ctx, "build feed")
+	xml, episodes, err := sh.paged(fillGaps(mergeEpisodes(episodes, before), backfill()))
+	span.SetAttr("episodes", len(episodes))
+	span.End(err)
+	return xml, episodes, err
+}
+
+// Find the show's old episodes on archive.org, filtered and retitled
+func (sh show) backfill(ctx context.Context) ([]scraper.Episode, error) {
+	ctx, span := trace.Start(ctx, "backfill")
+	episodes, err := sh.scraper().ArchiveOrgContext(ctx, sh.Backfill)
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("found %d episodes of %s on archive.org", len(episodes), sh.Slug)
+	return sh.prepare(episodes)
+}
+
+// Looks for a show's old episodes on archive.org alongside refreshes, so
+// the first feed doesn't wait on it, until it answers once
+type backfiller struct {
+	show show
+
+	mu       sync.Mutex
+	running  bool
+	done     bool
+	backfill []scraper.Episode
+}
+
+// Look for the episodes in the background, if they haven't been found and
+// aren't being looked for, and rebuild state's feed with them once they
+// are
+func (b *backfiller) start(state *server.State) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done || b.running {
+		return
+	}
+	b.running = true
+
+	go func() {
+		ctx := context.Background()
+		episodes, err := b.show.backfill(ctx)
+		if err != nil {
+			log.Printf("error backfilling %s from archive.org: %s", b.show.Slug, err)
+			reportRefresh(ctx, b.show, err)
+		}
+		b.mu.Lock()
+		b.running = false
+		if err == nil {
+			b.done = true
+			b.backfill = episodes
+		}
+		b.mu.Unlock()
+
+		if err == nil {
+			if _, err := state.Rebuild(); err != nil {
+				log.Printf("error adding backfilled episodes to %s: %s", b.show.Slug, err)
+			}
+		}
+	}()
+}
+
+// The episodes found so far
+func (b *backfiller) episodes() []scraper.Episode {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.backfill
+}
+
+// Add the extra episodes from days there are no episodes for, newest
+// first
+func fillGaps(episodes, extra []scraper.Episode) []scraper.Episode {
+	if len(extra) == 0 {
+		return episodes
+	}
+
+	days := map[string]bool{}
+	for _, e := range episodes {
+		days[e.PubDate.Format("2006-01-02")] = true
+	}
+	var gaps []scraper.Episode
+	for _, e := range extra {
+		if day := e.PubDate.Format("2006-01-02"); !days[day] {
+			days[day] = true
+			gaps = append(gaps, e)
+		}
+	}
+	return mergeEpisodes(episodes, gaps)
+}
+
+// What tells episodes apart: the UUID, or the MP3's URL for any without
+// one
+func episodeKey(e scraper.Episode) string {
+	if e.UUID != "" {
+		return e.UUID
+	}
+	return e.MP3
+}
+
+// Drop repeats of episodes (e.g. listed twice on KCRW's page), keeping the
+// first copy of each
+func dedupe(episodes []scraper.Episode) []scraper.Episode {
+	unique := make([]scraper.Episode, 0, len(episodes))
+	seen := map[string]bool{}
+	for _, e := range episodes {
+		if !seen[episodeKey(e)] {
+			seen[episodeKey(e)] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// Add the episodes from before that aren't in the scraped ones, newest
+// first. The scraped copy of an episode in both wins, in case KCRW has
+// changed it, but keeps the GUID it had before
+func mergeEpisodes(scraped, before []scraper.Episode) []scraper.Episode {
+	guids := map[string]string{}
+	for _, e := range before {
+		if e.GUID != "" {
+			guids[episodeKey(e)] = e.GUID
+		}
+	}
+	episodes := dedupe(scraped)
+	seen := map[string]bool{}
+	for i, e := range episodes {
+		seen[episodeKey(e)] = true
+		if e.GUID == "" {
+			episodes[i].GUID = guids[episodeKey(e)]
+		}
+	}
+	for _, e := range before {
+		if !seen[episodeKey(e)] {
+			seen[episodeKey(e)] = true
+			episodes = append(episodes, e)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].PubDate.After(episodes[j].PubDate)
+	})
+	return episodes
+}
+
+// Build the show's subscription feed, linking to archives of older
+// episodes if it's paged. The episodes returned are all of them that
+// passed the show's filters, retitled
+func (sh show) build(episodes []scraper.Episode) (string, []scraper.Episode, error) {
+	episodes, err := sh.prepare(episodes)
+	if err != nil {
+		return "", nil, err
+	}
+	return sh.paged(episodes)
+}
+
+// Build the feed of episodes that have already been prepared
+func (sh show) paged(episodes []scraper.Episode) (string, []scraper.Episode, error) {
+	episodes = sh.assignGUIDs(feed.NewestFirst(dedupe(episodes)))
+	var page feed.Page
+	if _, archives := feed.Paginate(episodes, sh.pageSize()); len(archives) > 0 {
+		page.PrevArchive = sh.archiveURL(len(archives))
+	}
+
+	b := sh.builder()
+	b.Built = time.Now()
+	xml, err := b.BuildPage(sh.current(episodes), page)
+	if err != nil {
+		return "", nil, err
+	}
+	return xml, episodes, nil
+}
+
+// The episodes the show's subscription feed has: the newest page of them
+// if it's split into archives, or as many as its limit allows
+func (sh show) current(episodes []scraper.Episode) []scraper.Episode {
+	current, archives := feed.Paginate(episodes, sh.pageSize())
+	if limit := sh.limit(); len(archives) == 0 && limit > 0 && len(current) > limit {
+		current = current[:limit]
+	}
+	return current
+}
+
+// The feed as Atom and JSON Feed, for clients asking for those instead
+func (sh show) formats() server.Formats {
+	// Built like the RSS, when the feed's content changes
+	builder := func() *feed.FeedBuilder {
+		b := sh.builder()
+		b.Built = time.Now()
+		return b
+	}
+	jsonFeed := func(episodes []scraper.Episode) (string, error) {
+		return builder().BuildJSON(episodes)
+	}
+	return server.Formats{
+		"application/atom+xml": func(episodes []scraper.Episode) (string, error) {
+			return builder().BuildAtom(episodes)
+		},
+		"application/feed+json": jsonFeed,
+		"application/json":      jsonFeed,
+	}
+}
+
+// Filter and retitle scraped episodes as the show is configured to,
+// holding back those not due out yet
+func (sh show) prepare(episodes []scraper.Episode) ([]scraper.Episode, error) {
+	return sh.retitle(sh.filter(sh.release(episodes)))
+}
+
+// Episodes already logged as held back, by show and UUID, so each is only
+// logged once however many refreshes hold it back
+var heldBack sync.Map
+
+// Drop episodes broadcast less than the show's publish delay ago, going by
+// their publication dates rather than when KCRW put them up. They're found
+// again, and published, by the first refresh after it's passed
+func (sh show) release(episodes []scraper.Episode) []scraper.Episode {
+	delay := sh.publishDelay()
+	if delay <= 0 {
+		return episodes
+	}
+
+	now := time.Now()
+	released := make([]scraper.Episode, 0, len(episodes))
+	for _, e := range episodes {
+		if due := e.PubDate.Add(delay); due.After(now) {
+			if _, logged := heldBack.LoadOrStore(sh.Slug+"/"+e.UUID, true); !logged {
+				log.Printf("holding back %q of %s until %s", e.Title, sh.Slug, due.Format(time.RFC3339))
+			}
+			continue
+		}
+		released = append(released, e)
+	}
+	return released
+}
+
+// Drop episodes the show's include and exclude keywords rule out
+func (sh show) filter(episodes []scraper.Episode) []scraper.Episode {
+	if len(sh.Include) == 0 && len(sh.Exclude) == 0 {
+		return episodes
+	}
+
+	contains := func(text string, keywords []string) bool {
+		for _, k := range keywords {
+			if strings.Contains(text, strings.ToLower(k)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var kept []scraper.Episode
+	for _, e := range episodes {
+		text := strings.ToLower(e.Title + "\n" + e.Description)
+		if len(sh.Include) > 0 && !contains(text, sh.Include) {
+			continue
+		}
+		if contains(text, sh.Exclude) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// Strip the configured prefixes from episode titles and apply the title
+// template
+func (sh show) retitle(episodes []scraper.Episode) ([]scraper.Episode, error) {
+	if len(sh.StripTitle) == 0 && sh.titleTemplate == nil {
+		return episodes, nil
+	}
+
+	retitled := make([]scraper.Episode, 0, len(episodes))
+	for _, e := range episodes {
+		for _, prefix := range sh.StripTitle {
+			if strings.HasPrefix(e.Title, prefix) {
+				e.Title = strings.TrimLeft(strings.TrimPrefix(e.Title, prefix), " -–—:|")
+				break
+			}
+		}
+
+		if sh.titleTemplate != nil {
+			var buf bytes.Buffer
+			if err := sh.titleTemplate.Execute(&buf, e); err != nil {
+				return nil, fmt.Errorf("error applying title template: %s", err)
+			}
+			e.Title = strings.TrimSpace(buf.String())
+		}
+		retitled = append(retitled, e)
+	}
+	return retitled, nil
+}
+
+func (sh show) limit() int {
+	if sh.Limit == 0 {
+		return envInt("FEED_LIMIT", 0)
+	}
+	return sh.Limit
+}
+
+var uuidRE = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Check the show's Podcasting 2.0 tags make sense
+func (sh show) checkPodcastTags() error {
+	if sh.PodcastGUID != "" && !uuidRE.MatchString(sh.PodcastGUID) {
+		return fmt.Errorf("invalid podcast_guid %q, want a lowercase UUID", sh.PodcastGUID)
+	}
+	if sh.LockedOwner != "" && sh.Locked == nil {
+		return errors.New("locked_owner set without locked")
+	}
+	for i, f := range sh.Funding {
+		if u, err := url.Parse(f.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("funding %d: invalid url %q", i+1, f.URL)
+		}
+	}
+	for i, p := range sh.Persons {
+		if strings.TrimSpace(p.Name) == "" {
+			return fmt.Errorf("person %d has no name", i+1)
+		}
+	}
+	return nil
+}
+
+// The show's guid, or FEED_GUID (uuid by default)
+func (sh show) guidScheme() string {
+	if sh.GUID != "" {
+		return sh.GUID
+	}
+	if guid := getenv("FEED_GUID"); guid != "" {
+		return guid
+	}
+	return "uuid"
+}
+
+// The show's order, or FEED_ORDER (newest by default)
+func (sh show) order() string {
+	if sh.Order != "" {
+		return sh.Order
+	}
+	if order := getenv("FEED_ORDER"); order != "" {
+		return order
+	}
+	return "newest"
+}
+
+// Give episodes that haven't been in the feed yet their GUIDs. Episodes
+// without a page to link to are known by their UUID whatever the scheme
+func (sh show) assignGUIDs(episodes []scraper.Episode) []scraper.Episode {
+	assigned := make([]scraper.Episode, len(episodes))
+	for i, e := range episodes {
+		if e.GUID == "" {
+			e.GUID = e.UUID
+			if u, err := url.Parse(e.Link); sh.guidURL && err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				e.GUID = e.Link
+			}
+		}
+		assigned[i] = e
+	}
+	return assigned
+}
+
+// How often the show's feed is refreshed, as near as a fixed interval can
+// say: its own refresh_interval, or REFRESH_INTERVAL
+func (sh show) refreshInterval() time.Duration {
+	if sh.interval > 0 {
+		return sh.interval
+	}
+	return envDuration("REFRESH_INTERVAL", time.Hour)
+}
+
+func (sh show) publishDelay() time.Duration {
+	if sh.PublishDelay != "" {
+		return sh.delay
+	}
+	return envDuration("PUBLISH_DELAY", 0)
+}
+
+// Build a feed of some of the show's episodes, without archive links
+func (sh show) render(episodes []scraper.Episode) (string, error) {
+	return sh.builder().Build(episodes)
+}
+
+// Whether the show's feeds are built without indentation
+func (sh show) compactXML() bool {
+	if sh.compact != nil {
+		return *sh.compact
+	}
+	v := xmlFormat
+	if v == "" {
+		v = getenv("FEED_XML")
+	}
+	// Checked with the rest of the settings
+	compact, _ := parseXMLFormat(v)
+	return compact
+}
+
+// The show with its feeds built compact or indented, whatever FEED_XML
+// says, for requests asking for one or the other
+func (sh show) formatted(compact bool) show {
+	sh.compact = &compact
+	return sh
+}
+
+func (sh show) pageSize() int {
+	if sh.PageSize == 0 {
+		return envInt("FEED_PAGE_SIZE", 0)
+	}
+	return sh.PageSize
+}
+
+// Where fanatic is public at, for links to it: the base URL, or the scheme
+// and host the show's feed is public at. Nothing (so links are relative) if
+// that isn't known
+func (sh show) base() string {
+	if base := baseURL(); base != "" {
+		return base
+	}
+	u, err := url.Parse(sh.FeedURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (sh show) currentURL() string {
+	if sh.FeedURL != "" {
+		return sh.FeedURL
+	}
+	return "/shows/" + sh.Slug + "/rss.xml"
+}
+
+// Where archive page n (1 being the oldest) is served
+func (sh show) archiveURL(n int) string {
+	return fmt.Sprintf("%s/shows/%s/archive/%d.xml", sh.base(), sh.Slug, n)
+}
+
+// Build archive page n of the show's feed, if there is one
+func (sh show) archive(episodes []scraper.Episode, n int) (string, bool) {
+	_, archives := feed.Paginate(episodes, sh.pageSize())
+	if n < 1 || n > len(archives) {
+		return "", false
+	}
+
+	page := feed.Page{Archive: true, Self: sh.archiveURL(n), Current: sh.currentURL()}
+	if n > 1 {
+		page.PrevArchive = sh.archiveURL(n - 1)
+	}
+	if n < len(archives) {
+		page.NextArchive = sh.archiveURL(n + 1)
+	}
+
+	xml, err := sh.builder().BuildPage(archives[n-1], page)
+	if err != nil {
+		log.Printf("error building archive %d of %s: %s", n, sh.Slug, err)
+		return "", false
+	}
+	return xml, true
+}
+
+// Merge the episodes of each of the show's sources into one list, newest
+// first, with the source's title in front of each episode's. Episodes
+// listed by more than one show only appear once
+func (sh show) combine(lists [][]scraper.Episode) []scraper.Episode {
+	var episodes []scraper.Episode
+	seen := map[string]bool{}
+	for i, list := range lists {
+		title := sh.sources[i].builder().Title
+		for _, e := range list {
+			if seen[episodeKey(e)] {
+				continue
+			}
+			seen[episodeKey(e)] = true
+			e.Title = title + ": " + e.Title
+			// Shows' numbers mean nothing next to each other's
+			e.Number = 0
+			episodes = append(episodes, e)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].PubDate.After(episodes[j].PubDate)
+	})
+	return episodes
+}
+
+// Tell WebSub and Podping subscribers the show's feed has changed
+func (sh show) announce() {
+	if err := webSub(sh.FeedURL).Publish(); err != nil {
+		log.Printf("error pinging websub hub: %s", err)
+	}
+	if pp := podping(sh.FeedURL); pp != nil {
+		if err := pp.Publish(); err != nil {
+			log.Printf("error sending podping: %s", err)
+		}
+	}
+}
+
+// Announcements being sent in the background, for those that have to
+// finish before exiting (or, in Lambda, being frozen) to wait for
+var announcing sync.WaitGroup
+
+// Returns a function announcing that the show's feed has changed in the
+// background, so a slow hub doesn't hold up the refresh
+func (sh show) announcer() func() {
+	return func() {
+		announcing.Add(1)
+		go func() {
+			defer announcing.Done()
+			sh.announce()
+		}()
+	}
+}
+
+// A state for a show, which keeps every episode it's ever scraped
+func (sh show) newState() *server.State {
+	var state *server.State
+	bf := &backfiller{show: sh, done: sh.Backfill == ""}
+	state = server.NewState(func() (string, []scraper.Episode, error) {
+		ctx, span := trace.Start(context.Background(), "refresh "+sh.Slug)
+		bf.start(state)
+		_, before, _ := state.Get()
+		xml, episodes, err := sh.generateKeeping(ctx, before, bf.episodes)
+		span.End(err)
+		reportRefresh(ctx, sh, err)
+		return xml, episodes, err
+	})
+	state.Same = feed.SameContent
+	state.Render = func(episodes []scraper.Episode) (string, []scraper.Episode, error) {
+		return sh.paged(fillGaps(episodes, bf.episodes()))
+	}
+	state.Monitor = monitorFromEnv(sh)
+	state.OnNew = notifyNew(sh, episodeNotifier())
+	state.OnChange = sh.announcer()
+	return state
+}
+
+// A state for a combined show, built from its sources' states rather than
+// scraping them again. Its episodes were already announced (and scraping
+// failures alerted on) by the sources
+func (sh show) combinedState(sources []*server.State) *server.State {
+	state := server.NewState(func() (string, []scraper.Episode, error) {
+		var lists [][]scraper.Episode
+		total := 0
+		for _, src := range sources {
+			_, episodes, _ := src.Get()
+			lists = append(lists, episodes)
+			total += len(episodes)
+		}
+		if total == 0 {
+			return "", nil, scraper.ErrNoEpisodes
+		}
+		return sh.build(sh.combine(lists))
+	})
+	state.Same = feed.SameContent
+	state.Render = sh.paged
+	state.OnChange = sh.announcer()
+	return state
+}
+
+// States for every show, ready to serve. Combined shows are refreshed
+// whenever one of their sources changes
+func newShows(shows []show) []server.Show {
+	states := map[string]*server.State{}
+	for _, sh := range shows {
+		if len(sh.sources) == 0 {
+			states[sh.Slug] = sh.newState()
+		}
+	}
+
+	for _, sh := range shows {
+		if len(sh.sources) == 0 {
+			continue
+		}
+		var sources []*server.State
+		for _, src := range sh.sources {
+			sources = append(sources, states[src.Slug])
+		}
+		combined := sh.combinedState(sources)
+		states[sh.Slug] = combined
+
+		for _, src := range sources {
+			onChange := src.OnChange
+			src.OnChange = func() {
+				onChange()
+				combined.Refresh()
+			}
+		}
+	}
+
+	var served []server.Show
+	for _, sh := range shows {
+		sh := sh
+		served = append(served, server.Show{
+			Slug:  sh.Slug,
+			State: states[sh.Slug],
+			Archive: func(episodes []scraper.Episode, n int, compact bool) (string, bool) {
+				return sh.formatted(compact).archive(episodes, n)
+			},
+			Render: func(episodes []scraper.Episode, compact bool) (string, error) {
+				return sh.formatted(compact).render(episodes)
+			},
+			Build: func(episodes []scraper.Episode, compact bool) (string, error) {
+				xml, _, err := sh.formatted(compact).paged(episodes)
+				return xml, err
+			},
+			Formats: sh.formats(),
+			Current: sh.current,
+		})
+	}
+	return served
+}
+
+// The shows in the order they should be refreshed, sources before the
+// shows combining them
+func refreshOrder(shows []show, served []server.Show) []server.Show {
+	var order, combined []server.Show
+	for i, sh := range shows {
+		if len(sh.sources) == 0 {
+			order = append(order, served[i])
+		} else {
+			combined = append(combined, served[i])
+		}
+	}
+	return append(order, combined...)
+}
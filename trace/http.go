@@ -0,0 +1,92 @@
+package trace
+
+import (
+	"net/http"
+)
+
+// Transport returns a RoundTripper making a client span of every request
+// it passes on to next (http.DefaultTransport if nil), with the span's
+// traceparent sent along
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper{next}
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartKind(req.Context(), req.Method+" "+req.URL.Host, KindClient)
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.URL.String())
+
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.TraceParent())
+	res, err := t.next.RoundTrip(req)
+	if err == nil {
+		span.SetAttr("http.status_code", res.StatusCode)
+	}
+	span.End(err)
+	return res, err
+}
+
+// Records the status code a handler writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Streamed responses (e.g. media) are flushed as they go
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Handler makes a server span of every request to next, continuing the
+// caller's trace if it sent a traceparent
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := WithTraceParent(req.Context(), req.Header.Get("traceparent"))
+		ctx, span := StartKind(ctx, req.Method+" "+req.URL.Path, KindServer)
+		if span == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		span.SetAttr("http.method", req.Method)
+		span.SetAttr("http.target", req.URL.RequestURI())
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttr("http.status_code", rec.status)
+		span.End(nil)
+	})
+}
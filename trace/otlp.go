@@ -0,0 +1,264 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many ended spans are held for export, beyond which new ones are
+// dropped
+const maxQueued = 2048
+
+// Exporter sends ended spans to an OTLP/HTTP collector in batches
+type Exporter struct {
+	// Endpoint is where traces are POSTed, e.g.
+	// http://localhost:4318/v1/traces
+	Endpoint string
+
+	// Headers are added to every export, e.g. for authentication
+	Headers map[string]string
+
+	// Service names this program in the exported resource
+	Service string
+
+	// Interval between exports, 5s if zero
+	Interval time.Duration
+
+	// Transport makes the requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+
+	mu      sync.Mutex
+	queue   []ended
+	dropped int
+}
+
+type ended struct {
+	span *Span
+	end  time.Time
+}
+
+// FromEnv returns an Exporter configured by the standard environment
+// variables, or nil if OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) isn't set or OTEL_SDK_DISABLED is
+// true. Only the http/json protocol is supported
+func FromEnv() (*Exporter, error) {
+	return FromGetenv(os.Getenv)
+}
+
+// FromGetenv is FromEnv with the variables looked up by getenv, e.g. to
+// take them from a config file too
+func FromGetenv(getenv func(string) string) (*Exporter, error) {
+	if getenv("OTEL_SDK_DISABLED") == "true" || getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil, nil
+	}
+
+	endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+
+	protocol := getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	// http/protobuf is the usual default, and collectors taking it take
+	// JSON at the same endpoint, which is what's sent either way
+	if protocol != "" && protocol != "http/json" && protocol != "http/protobuf" {
+		return nil, fmt.Errorf("OTLP protocol %q isn't supported, only http/json (or http/protobuf, sent as JSON)", protocol)
+	}
+
+	e := &Exporter{Endpoint: endpoint, Service: getenv("OTEL_SERVICE_NAME"), Headers: map[string]string{}}
+	if e.Service == "" {
+		e.Service = "fanatic"
+	}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		for _, kv := range strings.Split(getenv(name), ",") {
+			i := strings.Index(kv, "=")
+			if i < 0 {
+				continue
+			}
+			v, err := url.QueryUnescape(strings.TrimSpace(kv[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", name, err)
+			}
+			e.Headers[strings.TrimSpace(kv[:i])] = v
+		}
+	}
+	return e, nil
+}
+
+func (e *Exporter) add(s *Span, end time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueued {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, ended{s, end})
+}
+
+// Run exports queued spans every Interval until ctx is done, then exports
+// whatever's left
+func (e *Exporter) Run(ctx context.Context) {
+	interval := e.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := e.Flush(); err != nil {
+				log.Printf("error exporting spans: %s", err)
+			}
+			return
+		case <-ticker.C:
+		}
+		if err := e.Flush(); err != nil {
+			log.Printf("error exporting spans: %s", err)
+		}
+	}
+}
+
+// Flush exports the queued spans now
+func (e *Exporter) Flush() error {
+	e.mu.Lock()
+	queue, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("dropped %d spans, the exporter can't keep up", dropped)
+	}
+	if len(queue) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.encode(queue))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Transport: e.Transport, Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return nil
+}
+
+// OTLP's JSON encoding of spans
+type otlpValue map[string]interface{}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+func attrValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{"stringValue": v}
+	case bool:
+		return otlpValue{"boolValue": v}
+	case int:
+		return otlpValue{"intValue": strconv.Itoa(v)}
+	case int64:
+		return otlpValue{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return otlpValue{"doubleValue": v}
+	default:
+		return otlpValue{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func (e *Exporter) encode(queue []ended) interface{} {
+	var spans []otlpSpan
+	for _, q := range queue {
+		s := q.span
+		out := otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(q.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+
+		s.mu.Lock()
+		keys := make([]string, 0, len(s.attrs))
+		for k := range s.attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out.Attributes = append(out.Attributes, otlpAttr{k, attrValue(s.attrs[k])})
+		}
+		if s.err != nil {
+			out.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+		spans = append(spans, out)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttr{{"service.name", attrValue(e.Service)}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/djl/fanatic"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,155 @@
+// Package trace records OpenTelemetry spans for scrapes and requests and
+// exports them over OTLP/HTTP, JSON encoded, configured with the standard
+// OTEL_* environment variables. It's nothing like the full SDK, just
+// enough to follow a refresh or a request through a collector.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span kinds, as OTLP numbers them
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Span is an operation being timed. A nil *Span, which Start returns when
+// tracing is off, can be used as normal and records nothing
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	err   error
+	ended bool
+
+	exp *Exporter
+}
+
+type ctxKey struct{}
+
+// The exporter spans go to, nil when tracing is off
+var (
+	globalMu sync.RWMutex
+	global   *Exporter
+)
+
+// Install makes Start record spans and send them to e
+func Install(e *Exporter) {
+	globalMu.Lock()
+	global = e
+	globalMu.Unlock()
+}
+
+func installed() *Exporter {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// Start begins a span as a child of the one in ctx, if any, returning a
+// context holding it
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+// StartKind is Start for spans of the given kind
+func StartKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	exp := installed()
+	if exp == nil {
+		return ctx, nil
+	}
+
+	s := &Span{name: name, kind: kind, start: time.Now(), exp: exp}
+	if parent := FromContext(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		s.traceID, s.parentID = remote.traceID, remote.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, ctxKey{}, s), s
+}
+
+// FromContext returns the span in ctx, nil if there isn't one
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(ctxKey{}).(*Span)
+	return s
+}
+
+// SetAttr records an attribute of the span: a string, bool, int, int64 or
+// float64
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = value
+}
+
+// End finishes the span, marking it failed if err isn't nil, and queues it
+// for export. Only the first call counts
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.err = true, err
+	s.mu.Unlock()
+	s.exp.add(s, time.Now())
+}
+
+// TraceParent returns the span as a W3C traceparent header value
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// A parent span from another service, read from a traceparent header
+type remoteKey struct{}
+
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// WithTraceParent returns a context whose spans continue the trace in a
+// W3C traceparent header value, or ctx if it isn't valid
+func WithTraceParent(ctx context.Context, header string) context.Context {
+	if len(header) != 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return ctx
+	}
+	var p remoteParent
+	if _, err := hex.Decode(p.traceID[:], []byte(header[3:35])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(p.spanID[:], []byte(header[36:52])); err != nil {
+		return ctx
+	}
+	if p.traceID == [16]byte{} || p.spanID == [8]byte{} {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, p)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djl/fanatic/server"
+)
+
+// The transcripts serve links episodes to, if TRANSCRIPT_DIR,
+// TRANSCRIPT_URL or TRANSCRIPT_SERVICE is set
+var transcripts *server.Transcripts
+
+// Configure where transcripts come from: files in TRANSCRIPT_DIR, the
+// TRANSCRIPT_URL pattern or the speech recognition service at
+// TRANSCRIPT_SERVICE. They're kept in STATE_DIR/transcripts, or in memory
+// without a STATE_DIR. nil if none is set
+func transcriptsFromEnv() (*server.Transcripts, error) {
+	var provider server.TranscriptProvider
+	retry := time.Hour
+	set := 0
+	if dir := getenv("TRANSCRIPT_DIR"); dir != "" {
+		// Looking costs nothing, so new files are found straight away
+		provider, retry = server.TranscriptDir(dir), 0
+		set++
+	}
+	if pattern := getenv("TRANSCRIPT_URL"); pattern != "" {
+		if !strings.Contains(pattern, "{uuid}") && !strings.Contains(pattern, "{number}") {
+			return nil, errors.New("TRANSCRIPT_URL needs {uuid} or {number} in it")
+		}
+		provider = server.TranscriptURL{Pattern: pattern, Transport: politeTransport(nil)}
+		set++
+	}
+	if service := getenv("TRANSCRIPT_SERVICE"); service != "" {
+		provider = server.TranscriptService{URL: service, Token: getenv("TRANSCRIPT_SERVICE_TOKEN"), Transport: politeTransport(nil)}
+		set++
+	}
+	switch {
+	case set == 0:
+		return nil, nil
+	case set > 1:
+		return nil, errors.New("only one of TRANSCRIPT_DIR, TRANSCRIPT_URL and TRANSCRIPT_SERVICE can be set")
+	}
+
+	dir := ""
+	if state := getenv("STATE_DIR"); state != "" {
+		dir = filepath.Join(state, "transcripts")
+	}
+	t := server.NewTranscripts(provider, dir)
+	t.Retry = retry
+	return t, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of an RSS document validateFeed looks at
+type rssDoc struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title string `xml:"title"`
+
+		// atom:links have the same name, so the channel's own link is
+		// the one without a namespace
+		Links []struct {
+			XMLName xml.Name
+			Href    string `xml:",chardata"`
+		} `xml:"link"`
+
+		Description   string  `xml:"description"`
+		LastBuildDate string  `xml:"lastBuildDate"`
+		TTL           *string `xml:"ttl"`
+		Items         []struct {
+			Title     string `xml:"title"`
+			GUID      string `xml:"guid"`
+			PubDate   string `xml:"pubDate"`
+			Enclosure *struct {
+				URL    string `xml:"url,attr"`
+				Length string `xml:"length,attr"`
+				Type   string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// Lint a generated feed, returning a description of each problem found
+func validateFeed(b []byte) []string {
+	var doc rssDoc
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return []string{fmt.Sprintf("not a valid RSS document: %s", err)}
+	}
+
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if doc.Version != "2.0" {
+		add("rss version is %q, want 2.0", doc.Version)
+	}
+
+	ch := doc.Channel
+	if ch.Title == "" {
+		add("channel has no title")
+	}
+	link := ""
+	for _, l := range ch.Links {
+		if l.XMLName.Space == "" {
+			link = strings.TrimSpace(l.Href)
+		}
+	}
+	if link == "" {
+		add("channel has no link")
+	}
+	if ch.Description == "" {
+		add("channel has no description")
+	}
+	if ch.LastBuildDate != "" && !rfc822Date(ch.LastBuildDate) {
+		add("channel has lastBuildDate %q, want an RFC 822 date", ch.LastBuildDate)
+	}
+	if ch.TTL != nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(*ch.TTL)); err != nil || n < 0 {
+			add("channel has ttl %q, want a whole number of minutes", *ch.TTL)
+		}
+	}
+	if len(ch.Items) == 0 {
+		add("feed has no items")
+	}
+
+	guids := map[string]int{}
+	for i, item := range ch.Items {
+		if item.Title == "" {
+			add("item %d has no title", i+1)
+		}
+		if item.GUID == "" {
+			add("item %d (%s) has no guid", i+1, item.Title)
+		} else if first, ok := guids[item.GUID]; ok {
+			add("item %d (%s) has the same guid as item %d, %q", i+1, item.Title, first, item.GUID)
+		} else {
+			guids[item.GUID] = i + 1
+		}
+		if item.PubDate == "" {
+			add("item %d (%s) has no pubDate", i+1, item.Title)
+		} else if !rfc822Date(item.PubDate) {
+			add("item %d (%s) has pubDate %q, want an RFC 822 date", i+1, item.Title, item.PubDate)
+		}
+		if item.Enclosure == nil || item.Enclosure.URL == "" {
+			add("item %d (%s) has no enclosure", i+1, item.Title)
+			continue
+		}
+		if u, err := url.Parse(item.Enclosure.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("item %d (%s) has enclosure url %q, want an absolute http(s) URL", i+1, item.Title, item.Enclosure.URL)
+		}
+		if !strings.Contains(item.Enclosure.Type, "/") {
+			add("item %d (%s) has enclosure type %q, want a MIME type", i+1, item.Title, item.Enclosure.Type)
+		}
+		if n, err := strconv.ParseInt(item.Enclosure.Length, 10, 64); err != nil || n < 0 {
+			add("item %d (%s) has enclosure length %q, want a number of bytes", i+1, item.Title, item.Enclosure.Length)
+		}
+	}
+
+	return problems
+}
+
+// Whether s is an RFC 822 date, as RSS wants, with a two or four digit
+// year and the day of the week optional
+func rfc822Date(s string) bool {
+	for _, layout := range []string{
+		time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822,
+		"2 Jan 2006 15:04:05 -0700", "2 Jan 2006 15:04:05 MST",
+		"02 Jan 06 15:04:05 -0700", "02 Jan 06 15:04:05 MST",
+		"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}